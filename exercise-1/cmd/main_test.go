@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// Reply to a find returning the given book
+func bookReply(t *testing.T, book BookStore) bson.D {
+	t.Helper()
+	data, err := bson.Marshal(book)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc bson.D
+	if err = bson.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	return mtest.CreateCursorResponse(0, "test.information", mtest.FirstBatch, doc)
+}
+
+// The sample books are found by their ISBN alone, so a second start does not
+// insert them again, even after one of them was edited
+func TestPrepareDataFindsStoredBooks(t *testing.T) {
+	mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock)).Run("mock", func(mt *mtest.T) {
+		stored := []BookStore{
+			{BookName: "The Vortex", BookAuthor: "José Eustasio Rivera", BookISBN: "958-30-0804-4", BookPages: 292, BookYear: 1924},
+			{BookName: "Frankenstein", BookAuthor: "Mary Shelley", BookISBN: "978-3-649-64609-9", BookPages: 280, BookYear: 1818},
+			{BookName: "The Black Cat", BookAuthor: "Edgar Allan Poe", BookISBN: "978-3-99168-238-7", BookPages: 280, BookYear: 1843},
+		}
+		for _, book := range stored {
+			mt.AddMockResponses(bookReply(mt.T, book))
+		}
+		prepareData(mt.Client, mt.Coll)
+
+		for _, started := range mt.GetAllStartedEvents() {
+			switch started.CommandName {
+			case "insert":
+				mt.Error("a sample book was inserted again")
+			case "find":
+				filter := started.Command.Lookup("filter").Document()
+				if elements, _ := filter.Elements(); len(elements) != 1 || elements[0].Key() != "bookisbn" {
+					mt.Errorf("the filter %s is not the ISBN alone", filter)
+				}
+			}
+		}
+	})
+}
+
+// Problems with single sample books are logged and skipped instead of
+// stopping the server: a book stored twice, a failed lookup and a rejected
+// insert
+func TestPrepareDataSkipsProblems(t *testing.T) {
+	mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock)).Run("mock", func(mt *mtest.T) {
+		vortex := bson.D{{Key: "bookisbn", Value: "958-30-0804-4"}}
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "test.information", mtest.FirstBatch, vortex, vortex),
+			mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 13, Message: "not authorized"}),
+			mtest.CreateCursorResponse(0, "test.information", mtest.FirstBatch),
+			mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 0, Code: 11000, Message: "E11000 duplicate key error"}),
+		)
+		prepareData(mt.Client, mt.Coll)
+
+		var finds, inserts int
+		for _, started := range mt.GetAllStartedEvents() {
+			switch started.CommandName {
+			case "find":
+				finds++
+			case "insert":
+				inserts++
+			}
+		}
+		if finds != 3 || inserts != 1 {
+			mt.Errorf("sent %d finds and %d inserts, want 3 and 1", finds, inserts)
+		}
+	})
+}
+
+// Stores a book and reads it back, checking the field names in between
+func TestBookStoreRoundTrip(t *testing.T) {
+	mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock)).Run("mock", func(mt *mtest.T) {
+		book := BookStore{BookName: "Frankenstein", BookAuthor: "Mary Shelley", BookISBN: "978-3-649-64609-9", BookPages: 280, BookYear: 1818}
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		if _, err := mt.Coll.InsertOne(context.TODO(), book); err != nil {
+			mt.Fatal(err)
+		}
+		stored := mt.GetStartedEvent().Command.Lookup("documents").Array().Index(0).Value().Document()
+		for _, field := range []string{"_id", "bookname", "bookauthor", "bookisbn", "bookpages", "bookyear"} {
+			if _, err := stored.LookupErr(field); err != nil {
+				mt.Errorf("the stored book %s lacks %s", stored, field)
+			}
+		}
+
+		var doc bson.D
+		if err := bson.Unmarshal(stored, &doc); err != nil {
+			mt.Fatal(err)
+		}
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "test.information", mtest.FirstBatch, doc))
+		var found BookStore
+		if err := mt.Coll.FindOne(context.TODO(), bson.M{"bookisbn": book.BookISBN}).Decode(&found); err != nil {
+			mt.Fatal(err)
+		}
+		found.ID = primitive.NilObjectID
+		if found != book {
+			mt.Errorf("read back %+v, want %+v", found, book)
+		}
+	})
+}
+
+func TestDatabaseURIMissing(t *testing.T) {
+	t.Setenv("DATABASE_URI", "")
+	_, err := databaseURI()
+	if err == nil || !strings.Contains(err.Error(), "DATABASE_URI") {
+		t.Errorf("got %v, want an error naming DATABASE_URI", err)
+	}
+}
+
+func TestDatabaseURI(t *testing.T) {
+	t.Setenv("DATABASE_URI", "mongodb://mongo:27017")
+	if uri, err := databaseURI(); uri != "mongodb://mongo:27017" || err != nil {
+		t.Errorf("got %q, %v, want the URI of the environment", uri, err)
+	}
+}
+
+// Each operation gets a fresh deadline, so a read long after the first
+// one still succeeds. The window is shrunk to keep the test fast.
+func TestOperationsAfterTheFirstWindow(t *testing.T) {
+	mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock)).Run("mock", func(mt *mtest.T) {
+		defer func(timeout time.Duration) { opTimeout = timeout }(opTimeout)
+		opTimeout = 50 * time.Millisecond
+
+		book := BookStore{BookName: "Frankenstein", BookAuthor: "Mary Shelley", BookPages: 280, BookYear: 1818}
+		mt.AddMockResponses(bookReply(mt.T, book))
+		if books := findAllBooks(mt.Coll); len(books) != 1 {
+			mt.Fatalf("found %d books, want 1", len(books))
+		}
+
+		time.Sleep(2 * opTimeout)
+		mt.AddMockResponses(bookReply(mt.T, book))
+		if books := findAllBooks(mt.Coll); len(books) != 1 || books[0]["BookName"] != "Frankenstein" {
+			mt.Errorf("found %v after the first window, want Frankenstein", books)
+		}
+	})
+}
+
+// The client is not tied to a startup context, so preparing the database
+// still works once the first operation window is long over
+func TestPrepareDatabaseAfterTheFirstWindow(t *testing.T) {
+	mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock)).Run("mock", func(mt *mtest.T) {
+		defer func(timeout time.Duration) { opTimeout = timeout }(opTimeout)
+		opTimeout = 50 * time.Millisecond
+		collections := mtest.CreateCursorResponse(0, "exercise-1.$cmd.listCollections", mtest.FirstBatch,
+			bson.D{{Key: "name", Value: "information"}, {Key: "type", Value: "collection"}})
+
+		mt.AddMockResponses(collections)
+		if _, err := prepareDatabase(mt.Client, "exercise-1", "information"); err != nil {
+			mt.Fatal(err)
+		}
+
+		time.Sleep(2 * opTimeout)
+		mt.AddMockResponses(collections)
+		coll, err := prepareDatabase(mt.Client, "exercise-1", "information")
+		if err != nil || coll.Name() != "information" {
+			mt.Errorf("got %v, %v after the first window, want the collection", coll, err)
+		}
+	})
+}
+
+func TestWaitForDatabaseRetries(t *testing.T) {
+	calls := 0
+	ping := func(ctx context.Context) error {
+		calls++
+		if calls == 1 {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	if err := waitForDatabase(ping, 3, time.Millisecond); err != nil {
+		t.Fatalf("got %v, want the second ping to succeed", err)
+	}
+	if calls != 2 {
+		t.Errorf("pinged %d times, want 2", calls)
+	}
+}
+
+func TestWaitForDatabaseGivesUp(t *testing.T) {
+	calls := 0
+	down := errors.New("connection refused")
+	ping := func(ctx context.Context) error {
+		calls++
+		return down
+	}
+	if err := waitForDatabase(ping, 3, time.Millisecond); !errors.Is(err, down) {
+		t.Errorf("got %v, want the last ping error", err)
+	}
+	if calls != 3 {
+		t.Errorf("pinged %d times, want 3", calls)
+	}
+}
+
+func TestConnectRetriesFromEnv(t *testing.T) {
+	t.Setenv("MONGO_CONNECT_ATTEMPTS", "")
+	t.Setenv("MONGO_CONNECT_INTERVAL", "")
+	if attempts, interval, err := connectRetriesFromEnv(); attempts != 5 || interval != 2*time.Second || err != nil {
+		t.Errorf("got %d, %v, %v, want the defaults 5, 2s", attempts, interval, err)
+	}
+	t.Setenv("MONGO_CONNECT_ATTEMPTS", "10")
+	t.Setenv("MONGO_CONNECT_INTERVAL", "500ms")
+	if attempts, interval, err := connectRetriesFromEnv(); attempts != 10 || interval != 500*time.Millisecond || err != nil {
+		t.Errorf("got %d, %v, %v, want 10, 500ms", attempts, interval, err)
+	}
+	t.Setenv("MONGO_CONNECT_ATTEMPTS", "0")
+	if _, _, err := connectRetriesFromEnv(); err == nil {
+		t.Error("0 attempts were accepted")
+	}
+}
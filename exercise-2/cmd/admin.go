@@ -0,0 +1,24 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// Guards the /api/admin endpoints. Clients send the token of ADMIN_TOKEN as
+// "Authorization: Bearer <token>".
+func adminAuth(token string) echo.MiddlewareFunc {
+	return middleware.KeyAuthWithConfig(middleware.KeyAuthConfig{
+		Validator: func(key string, c echo.Context) (bool, error) {
+			// Constant time, so the token cannot be guessed from how long
+			// the comparison takes
+			return subtle.ConstantTimeCompare([]byte(key), []byte(token)) == 1, nil
+		},
+		ErrorHandler: func(err error, c echo.Context) error {
+			return respondError(c, http.StatusUnauthorized, codeUnauthorized, "a valid admin token is required")
+		},
+	})
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestResetRefusedWithoutAllowReset(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		repo := testRepo(mt)
+		repo.AdminToken = "secret"
+		repo.AllowReset = false
+		e := newTestServer(repo)
+
+		rec := serve(e, http.MethodPost, "/api/admin/reset", "", "Authorization", "Bearer secret")
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("status = %d, want 403", rec.Code)
+		}
+		if detail := decodeEnvelope(t, rec.Body.Bytes()); detail.Code != codeForbidden {
+			t.Errorf("code = %q, want %q", detail.Code, codeForbidden)
+		}
+		if started := mt.GetAllStartedEvents(); len(started) != 0 {
+			t.Errorf("sent %d commands for a refused reset", len(started))
+		}
+
+		// Without the token it does not even get that far
+		if rec := serve(e, http.MethodPost, "/api/admin/reset", ""); rec.Code != http.StatusUnauthorized {
+			t.Errorf("status = %d without a token, want 401", rec.Code)
+		}
+	})
+}
+
+// Dropping the collection drops its indexes, so a reset that cannot build
+// them again leaves us not ready
+func TestResetWithoutIndexes(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		repo := testRepo(mt)
+		repo.AdminToken = "secret"
+		repo.AllowReset = true
+		e := newTestServer(repo)
+		defer indexesReady.Store(indexesReady.Load())
+		indexesReady.Store(true)
+
+		// drop, create and the failing createIndexes
+		mt.AddMockResponses(mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse(),
+			mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 67, Message: "cannot create index"}))
+		rec := serve(e, http.MethodPost, "/api/admin/reset", "", "Authorization", "Bearer secret")
+		if rec.Code != http.StatusInternalServerError {
+			t.Fatalf("status = %d, want 500: %s", rec.Code, rec.Body.String())
+		}
+		if indexesReady.Load() {
+			t.Error("still ready without the book indexes")
+		}
+		if inserts := sentCommands(mt, "insert"); len(inserts) != 0 {
+			t.Error("the sample books were inserted without the indexes")
+		}
+	})
+}
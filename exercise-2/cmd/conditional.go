@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Builds the filter for updating a book, honouring If-Unmodified-Since: the
+// update then only matches if the book was not changed after that time, so
+// a client cannot overwrite a change it has not seen (a "lost update").
+// The second value reports whether the header was sent.
+func updateFilter(c echo.Context, id primitive.ObjectID) (bson.M, bool, error) {
+	filter := bson.M{"_id": id}
+	header := c.Request().Header.Get("If-Unmodified-Since")
+	if header == "" {
+		return filter, false, nil
+	}
+	since, err := http.ParseTime(header)
+	if err != nil {
+		return nil, true, fmt.Errorf("If-Unmodified-Since must be an HTTP date")
+	}
+	// HTTP dates have no fractions of a second, while updatedat has
+	filter["$or"] = bson.A{
+		bson.M{"updatedat": bson.M{"$lt": since.Add(time.Second)}},
+		bson.M{"updatedat": bson.M{"$exists": false}},
+	}
+	return filter, true, nil
+}
+
+// Answers an update that matched no book: 412 if the book exists but was
+// changed after If-Unmodified-Since, 404 otherwise
+func respondUpdateMiss(c echo.Context, coll *mongo.Collection, id primitive.ObjectID, conditional bool) error {
+	if conditional {
+		count, err := coll.CountDocuments(context.TODO(), bson.M{"_id": id})
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, codeInternal, "error in updating data")
+		}
+		if count > 0 {
+			return respondError(c, http.StatusPreconditionFailed, codePreconditionFailed, "the book was modified since If-Unmodified-Since")
+		}
+	}
+	return respondError(c, http.StatusNotFound, codeNotFound, "book does not exist")
+}
+
+// Sets Last-Modified, so clients can send it back as If-Unmodified-Since
+func setLastModified(c echo.Context, t time.Time) {
+	if !t.IsZero() {
+		c.Response().Header().Set(echo.HeaderLastModified, t.UTC().Format(http.TimeFormat))
+	}
+}
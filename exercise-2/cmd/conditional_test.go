@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestStaleIfUnmodifiedSince(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		book := seedBooks()[1]
+		target := "/api/books/" + book.ID.Hex()
+		seen := book.UpdatedAt.Format(http.TimeFormat)
+
+		// Someone else changes the book after we read it
+		mt.AddMockResponses(findAndModifyReply(t, book), writeReply(1))
+		rec := serve(e, http.MethodPut, target, `{"name":"Frankenstein","author":"Mary Shelley","pages":300,"year":1818}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+
+		// Our update, based on what we read, matches nothing any more, while
+		// the book is still there
+		mt.AddMockResponses(findAndModifyReply(t, nil), countReply(t, 1))
+		rec = serve(e, http.MethodPut, target, `{"name":"Frankenstein","author":"Mary Shelley","pages":280,"year":1818}`,
+			"If-Unmodified-Since", seen)
+		if rec.Code != http.StatusPreconditionFailed {
+			t.Errorf("status = %d, want 412: %s", rec.Code, rec.Body.String())
+		}
+		if detail := decodeEnvelope(t, rec.Body.Bytes()); detail.Code != codePreconditionFailed {
+			t.Errorf("code = %q, want %q", detail.Code, codePreconditionFailed)
+		}
+
+		filter := sentCommands(mt, "findAndModify")[1].Command.Lookup("query").Document()
+		if _, err := filter.LookupErr("$or"); err != nil {
+			t.Errorf("the update %s does not check updatedat", filter)
+		}
+	})
+}
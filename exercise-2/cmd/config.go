@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// Content-Security-Policy sent with every response unless overridden by
+// CONTENT_SECURITY_POLICY. The index page loads htmx from unpkg and its font
+// from Google, and uses inline styles and an inline script, so those have to
+// be allowed. Book covers can be hosted anywhere on the web.
+const defaultContentSecurityPolicy = "default-src 'self'; " +
+	"script-src 'self' 'unsafe-inline' https://unpkg.com; " +
+	"style-src 'self' 'unsafe-inline' https://fonts.googleapis.com; " +
+	"font-src 'self' https://fonts.gstatic.com; " +
+	"img-src 'self' http: https:"
+
+// Reads the read preference used by the listing endpoints from
+// READ_PREFERENCE (primary, primaryPreferred, secondary, secondaryPreferred
+// or nearest). On a replica set, anything but primary lets reads be served
+// by a secondary to offload the primary. Defaults to primary.
+func readPreferenceFromEnv() (*readpref.ReadPref, error) {
+	value := os.Getenv("READ_PREFERENCE")
+	if value == "" {
+		return readpref.Primary(), nil
+	}
+	mode, err := readpref.ModeFromString(value)
+	if err != nil {
+		return nil, err
+	}
+	return readpref.New(mode)
+}
+
+// Builds the collation the listing endpoints sort with from COLLATION_LOCALE
+// (default "en") and COLLATION_CASE_LEVEL. A locale sorts "José" next to
+// "Joseph" instead of after every ASCII name; "simple" keeps the plain byte
+// order of MongoDB. With the case level, names differing only in case are
+// told apart instead of being treated as equal.
+// More on collations: https://www.mongodb.com/docs/manual/reference/collation/
+func collationFromEnv() (*options.Collation, error) {
+	locale := os.Getenv("COLLATION_LOCALE")
+	if locale == "" {
+		locale = "en"
+	}
+	caseLevel, err := boolFromEnv("COLLATION_CASE_LEVEL", false)
+	if err != nil {
+		return nil, err
+	}
+	return &options.Collation{Locale: locale, CaseLevel: caseLevel}, nil
+}
+
+// Builds the write concern used for inserts, updates and deletes from
+// WRITE_CONCERN_W (a number or "majority") and WRITE_CONCERN_JOURNAL.
+// Returns nil when neither is set, which keeps the server default.
+//
+// Some tradeoffs to keep in mind:
+//   - w=0 does not wait for any acknowledgement. It is the fastest, but
+//     errors (like a duplicate key) are never reported back to us.
+//   - w=1 waits for the primary only. A write can still be rolled back if
+//     the primary fails before it was replicated.
+//   - w=majority waits for most members of the replica set, so acknowledged
+//     books survive a failover, at the cost of extra latency per write.
+//   - journal=true also waits for the write to reach the on-disk journal,
+//     protecting against a crash of the node itself but adding a disk flush
+//     to every write.
+func writeConcernFromEnv() (*writeconcern.WriteConcern, error) {
+	w := os.Getenv("WRITE_CONCERN_W")
+	journal := os.Getenv("WRITE_CONCERN_JOURNAL")
+	if w == "" && journal == "" {
+		return nil, nil
+	}
+
+	wc := &writeconcern.WriteConcern{}
+	if w == "majority" {
+		wc.W = "majority"
+	} else if w != "" {
+		n, err := strconv.Atoi(w)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("WRITE_CONCERN_W must be a non-negative number or majority, got %q", w)
+		}
+		wc.W = n
+	}
+	if journal != "" {
+		j, err := strconv.ParseBool(journal)
+		if err != nil {
+			return nil, fmt.Errorf("WRITE_CONCERN_JOURNAL must be a boolean, got %q", journal)
+		}
+		if j && wc.W == 0 {
+			return nil, fmt.Errorf("WRITE_CONCERN_JOURNAL cannot be enabled with WRITE_CONCERN_W=0")
+		}
+		wc.Journal = &j
+	}
+	return wc, nil
+}
+
+// The app name the client reports to the server (MONGO_APP_NAME). It shows
+// up in the server logs and the profiler, telling the services apart.
+func appNameFromEnv() string {
+	if appName := os.Getenv("MONGO_APP_NAME"); appName != "" {
+		return appName
+	}
+	return serviceName
+}
+
+// Reads a duration such as "30s" or "5m" from the given variable, returning
+// the fallback when it is not set.
+func durationFromEnv(name string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a duration like 30s, got %q", name, value)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("%s must not be negative, got %q", name, value)
+	}
+	return d, nil
+}
+
+// Reads a whole number from the given variable, returning the fallback when
+// it is not set.
+func intFromEnv(name string, fallback int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a number, got %q", name, value)
+	}
+	return n, nil
+}
+
+// Reads a boolean (true/false, 1/0, ...) from the given variable, returning
+// the fallback when it is not set.
+func boolFromEnv(name string, fallback bool) (bool, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%s must be true or false, got %q", name, value)
+	}
+	return b, nil
+}
+
+// Request log line used with LOG_FORMAT=text, for people reading the logs
+// in a terminal rather than a log pipeline
+const textLogFormat = "${time_rfc3339} ${remote_ip} ${method} ${uri} ${status} ${latency_human} ${error}\n"
+
+// Picks the request log format from LOG_FORMAT: json (the default, one
+// object per line as echo writes it) or text.
+func loggerConfigFromEnv() (middleware.LoggerConfig, error) {
+	config := middleware.DefaultLoggerConfig
+	switch format := os.Getenv("LOG_FORMAT"); format {
+	case "", "json":
+	case "text":
+		config.Format = textLogFormat
+	default:
+		return config, fmt.Errorf("LOG_FORMAT must be json or text, got %q", format)
+	}
+	return config, nil
+}
+
+// Reads how /books/ is treated from TRAILING_SLASH:
+//   - redirect (default): 308 to /books, which keeps the method and body,
+//     so it is safe for the API as well
+//   - rewrite: serve /books directly, without a redirect
+//   - off: /books/ is a different route and answers 404
+//
+// The returned middleware is nil for off.
+func trailingSlashFromEnv() (echo.MiddlewareFunc, error) {
+	switch value := os.Getenv("TRAILING_SLASH"); value {
+	case "", "redirect":
+		return middleware.RemoveTrailingSlashWithConfig(middleware.TrailingSlashConfig{
+			RedirectCode: http.StatusPermanentRedirect,
+		}), nil
+	case "rewrite":
+		return middleware.RemoveTrailingSlash(), nil
+	case "off":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("TRAILING_SLASH must be redirect, rewrite or off, got %q", value)
+	}
+}
+
+// Returns the MongoDB URI. DATABASE_URI_FILE, when set, names a file holding
+// it (e.g. a Docker secret under /run/secrets) and wins over DATABASE_URI.
+func databaseURIFromEnv() (string, error) {
+	if path := os.Getenv("DATABASE_URI_FILE"); path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read DATABASE_URI_FILE: %v", err)
+		}
+		uri := strings.TrimRight(string(content), "\r\n")
+		if uri == "" {
+			return "", fmt.Errorf("DATABASE_URI_FILE %s is empty", path)
+		}
+		return uri, nil
+	}
+	uri := os.Getenv("DATABASE_URI")
+	if uri == "" {
+		return "", fmt.Errorf("failure to load env variable")
+	}
+	return uri, nil
+}
+
+// Reads TLS_CERT_FILE and TLS_KEY_FILE. Both have to be set to serve HTTPS
+// and both files have to exist; with neither set we serve plain HTTP and
+// return empty paths.
+func tlsFilesFromEnv() (string, string, error) {
+	certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	if certFile == "" && keyFile == "" {
+		return "", "", nil
+	}
+	if certFile == "" || keyFile == "" {
+		return "", "", fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	for _, file := range []string{certFile, keyFile} {
+		if _, err := os.Stat(file); err != nil {
+			return "", "", fmt.Errorf("cannot read TLS file: %v", err)
+		}
+	}
+	return certFile, keyFile, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Columns of the CSV export, also understood by the import. The import only
+// needs a header row naming the columns, in any order.
+var csvColumns = []string{"id", "name", "author", "isbn", "pages", "year"}
+
+// Delimiters accepted in ?delimiter=. Spreadsheets set to a European locale
+// use semicolons, since the comma is their decimal separator.
+var csvDelimiters = map[string]rune{
+	",":   ',',
+	";":   ';',
+	"tab": '\t',
+	"\t":  '\t',
+	"|":   '|',
+}
+
+// Reads ?delimiter=, defaulting to a comma
+func csvDelimiterFromQuery(c echo.Context) (rune, error) {
+	value := c.QueryParam("delimiter")
+	if value == "" {
+		return ',', nil
+	}
+	delimiter, ok := csvDelimiters[value]
+	if !ok {
+		return 0, fmt.Errorf("delimiter must be one of , ; | or tab")
+	}
+	return delimiter, nil
+}
+
+// Writes the books as CSV, starting with a header row
+func writeBooksCSV(w io.Writer, books []BookDTO, delimiter rune) error {
+	writer := csv.NewWriter(w)
+	writer.Comma = delimiter
+	if err := writer.Write(csvColumns); err != nil {
+		return err
+	}
+	for _, book := range books {
+		record := []string{
+			book.Id,
+			book.Name,
+			book.Author,
+			book.Isbn,
+			strconv.Itoa(book.Pages),
+			strconv.Itoa(book.Year),
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// Parses books from CSV. The first row has to name the columns; unknown
+// columns (like id) are ignored. Pages and year may be left empty.
+func readBooksCSV(r io.Reader, delimiter rune) ([]PostBookDTO, error) {
+	reader := csv.NewReader(io.LimitReader(r, maxImportBytes))
+	reader.Comma = delimiter
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("the CSV is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %v", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["name"]; !ok {
+		return nil, fmt.Errorf("the CSV header has no name column")
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	books := make([]PostBookDTO, 0)
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %v", err)
+		}
+		if len(books) == maxImportBooks {
+			return nil, fmt.Errorf("the CSV holds more than %d books", maxImportBooks)
+		}
+
+		book := PostBookDTO{
+			Name:   field(record, "name"),
+			Author: field(record, "author"),
+			Isbn:   field(record, "isbn"),
+		}
+		if pages := field(record, "pages"); pages != "" {
+			if book.Pages, err = strconv.Atoi(pages); err != nil {
+				return nil, fmt.Errorf("line %d: pages must be a number", line)
+			}
+		}
+		if year := field(record, "year"); year != "" {
+			if book.Year, err = strconv.Atoi(year); err != nil {
+				return nil, fmt.Errorf("line %d: year must be a number", line)
+			}
+		}
+		books = append(books, book)
+	}
+	return books, nil
+}
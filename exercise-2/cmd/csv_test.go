@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestCSVRoundTripWithSemicolon(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		books := seedBooks()
+		books[1].BookName = "Frankenstein; or, The Modern Prometheus"
+
+		mt.AddMockResponses(booksReply(t, books...))
+		rec := serve(e, http.MethodGet, "/api/books/export.csv?delimiter=%3B", "")
+		if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/csv") {
+			t.Fatalf("got %d %q, want a CSV file", rec.Code, rec.Header().Get("Content-Type"))
+		}
+		if header, _, _ := strings.Cut(rec.Body.String(), "\n"); header != strings.Join(csvColumns, ";") {
+			t.Errorf("header = %q, want the columns separated by ;", header)
+		}
+
+		parsed, err := readBooksCSV(strings.NewReader(rec.Body.String()), ';')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(parsed) != len(books) {
+			t.Fatalf("read back %d books, want %d", len(parsed), len(books))
+		}
+		for i, book := range parsed {
+			want := books[i].ToDTO()
+			if book.Name != want.Name || book.Author != want.Author || book.Isbn != want.Isbn ||
+				book.Pages != want.Pages || book.Year != want.Year {
+				t.Errorf("book %d read back as %+v, want %+v", i, book, want)
+			}
+		}
+	})
+}
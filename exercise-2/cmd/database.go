@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Pings the database until it answers, trying at most attempts times and
+// waiting interval between tries. In container setups MongoDB often starts
+// a few seconds after us, so giving up on the first failed ping is too eager.
+// The ping is passed in as a function, so this does not need a real client.
+func waitForDatabase(ping func(ctx context.Context) error, attempts int, interval time.Duration) error {
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err = ping(ctx)
+		cancel()
+		if err == nil {
+			log.Printf("connected to MongoDB (attempt %d/%d)\n", attempt, attempts)
+			return nil
+		}
+		log.Printf("MongoDB is not reachable yet (attempt %d/%d): %v\n", attempt, attempts, err)
+		if attempt < attempts {
+			time.Sleep(interval)
+		}
+	}
+	return err
+}
+
+// How the books collection is created, set through COLLECTION_VALIDATOR and
+// COLLECTION_CAPPED_SIZE. Both only matter when the collection does not
+// exist yet, except the validator, which is added to an existing one too.
+type CollectionOptions struct {
+	// Lets the database itself reject books without a name or author, see
+	// bookCollectionValidator
+	Validator bool
+	// Size in bytes of a capped collection, 0 for a regular one. A capped
+	// collection drops its oldest books when full, but it does not allow
+	// deleting books, so the DELETE endpoints fail on it.
+	CappedSize int
+}
+
+func collectionOptionsFromEnv() (CollectionOptions, error) {
+	validator, err := boolFromEnv("COLLECTION_VALIDATOR", false)
+	if err != nil {
+		return CollectionOptions{}, err
+	}
+	cappedSize, err := intFromEnv("COLLECTION_CAPPED_SIZE", 0)
+	if err != nil {
+		return CollectionOptions{}, err
+	}
+	if cappedSize < 0 {
+		return CollectionOptions{}, fmt.Errorf("COLLECTION_CAPPED_SIZE must not be negative")
+	}
+	return CollectionOptions{Validator: validator, CappedSize: cappedSize}, nil
+}
+
+// The rules every stored book has to follow, checked by MongoDB on every
+// insert and update. This catches books written around our validation,
+// e.g. by another service or a manual import.
+// More on schema validation: https://www.mongodb.com/docs/manual/core/schema-validation/
+var bookCollectionValidator = bson.M{
+	"$jsonSchema": bson.M{
+		"bsonType": "object",
+		"required": bson.A{"bookname", "bookauthor"},
+		"properties": bson.M{
+			"bookname":   bson.M{"bsonType": "string", "minLength": 1},
+			"bookauthor": bson.M{"bsonType": "string", "minLength": 1},
+			"bookisbn":   bson.M{"bsonType": "string"},
+			// The driver stores Go ints as int32 or int64, depending on the size
+			"bookpages": bson.M{"bsonType": bson.A{"int", "long"}, "minimum": 0},
+			"bookyear":  bson.M{"bsonType": bson.A{"int", "long"}, "minimum": 0},
+			"tags":      bson.M{"bsonType": bson.A{"array", "null"}, "items": bson.M{"bsonType": "string"}},
+		},
+	},
+}
+
+// Builds the create command for the books collection
+// More on the command: https://www.mongodb.com/docs/manual/reference/command/create/
+func createCollectionCommand(name string, opts CollectionOptions) bson.D {
+	cmd := bson.D{{Key: "create", Value: name}}
+	if opts.CappedSize > 0 {
+		cmd = append(cmd, bson.E{Key: "capped", Value: true}, bson.E{Key: "size", Value: opts.CappedSize})
+	}
+	if opts.Validator {
+		cmd = append(cmd, bson.E{Key: "validator", Value: bookCollectionValidator})
+	}
+	return cmd
+}
+
+// Fills a field derived from another one, e.g. bookauthor_lower from
+// bookauthor, for books stored before it existed and returns how many were
+// updated. derive runs in Go rather than in the database, so the values
+// are the same as for new books (e.g. $toLower only lowercases ASCII
+// letters reliably).
+func backfillDerivedField(coll *mongo.Collection, source string, target string, derive func(string) string) (int64, error) {
+	opts := options.Find().SetProjection(bson.M{source: 1})
+	cursor, err := coll.Find(context.TODO(), bson.M{target: bson.M{"$exists": false}}, opts)
+	if err != nil {
+		return 0, err
+	}
+	var docs []bson.M
+	if err = cursor.All(context.TODO(), &docs); err != nil {
+		return 0, err
+	}
+	if len(docs) == 0 {
+		return 0, nil
+	}
+
+	updates := make([]mongo.WriteModel, 0, len(docs))
+	for _, doc := range docs {
+		value, _ := doc[source].(string)
+		updates = append(updates, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": doc["_id"]}).
+			SetUpdate(bson.M{"$set": bson.M{target: derive(value)}}))
+	}
+	result, err := coll.BulkWrite(context.TODO(), updates, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// Drops the books collection, creates it again like on the first start and
+// fills it with the sample books. Returns how many books it holds afterwards.
+// Everything else, like the history, is kept. Dropping the collection drops
+// its indexes too, so we are not ready until they are built again.
+func resetBooks(coll *mongo.Collection, opts CollectionOptions) (int64, error) {
+	indexesReady.Store(false)
+	if err := coll.Drop(context.TODO()); err != nil {
+		return 0, err
+	}
+	cmd := createCollectionCommand(coll.Name(), opts)
+	if err := coll.Database().RunCommand(context.TODO(), cmd).Err(); err != nil {
+		return 0, err
+	}
+	if err := prepareBookIndexes(coll); err != nil {
+		return 0, err
+	}
+	indexesReady.Store(true)
+	prepareData(coll.Database().Client(), coll)
+	return coll.CountDocuments(context.TODO(), bson.D{})
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestWaitForDatabaseRetries(t *testing.T) {
+	calls := 0
+	ping := func(ctx context.Context) error {
+		calls++
+		if calls == 1 {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	if err := waitForDatabase(ping, 3, time.Millisecond); err != nil {
+		t.Fatalf("got %v, want the second ping to succeed", err)
+	}
+	if calls != 2 {
+		t.Errorf("pinged %d times, want 2", calls)
+	}
+}
+
+func TestWaitForDatabaseGivesUp(t *testing.T) {
+	calls := 0
+	down := errors.New("connection refused")
+	ping := func(ctx context.Context) error {
+		calls++
+		return down
+	}
+	if err := waitForDatabase(ping, 3, time.Millisecond); !errors.Is(err, down) {
+		t.Errorf("got %v, want the last ping error", err)
+	}
+	if calls != 3 {
+		t.Errorf("pinged %d times, want 3", calls)
+	}
+}
+
+// Checks a document against the required fields and the minimum string
+// lengths of a $jsonSchema validator, the part of it MongoDB would reject a
+// book without a name or author with
+func passesValidator(t *testing.T, validator bson.Raw, doc bson.Raw) bool {
+	t.Helper()
+	schema := validator.Lookup("$jsonSchema").Document()
+	required, err := schema.Lookup("required").Array().Values()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range required {
+		if _, err := doc.LookupErr(field.StringValue()); err != nil {
+			return false
+		}
+	}
+	properties, err := schema.Lookup("properties").Document().Elements()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, property := range properties {
+		minLength, ok := property.Value().Document().Lookup("minLength").AsInt64OK()
+		value, err := doc.LookupErr(property.Key())
+		if ok && err == nil && int64(len(value.StringValue())) < minLength {
+			return false
+		}
+	}
+	return true
+}
+
+func TestCollectionValidator(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		// No collection yet, so it is created with the validator
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "exercise-1.$cmd.listCollections", mtest.FirstBatch),
+			mtest.CreateSuccessResponse(),
+		)
+		if _, err := prepareDatabase(mt.Client, "exercise-1", "information", CollectionOptions{Validator: true}); err != nil {
+			t.Fatal(err)
+		}
+		validator, err := sentCommands(mt, "create")[0].Command.LookupErr("validator")
+		if err != nil {
+			t.Fatal("the collection was created without a validator")
+		}
+
+		// Written around our API, e.g. by a manual import
+		withoutAuthor, err := bson.Marshal(bson.M{"bookname": "Frankenstein", "bookpages": 280})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if passesValidator(t, validator.Document(), withoutAuthor) {
+			t.Error("a book without an author passes the validator")
+		}
+		complete, err := bson.Marshal(seedBooks()[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !passesValidator(t, validator.Document(), complete) {
+			t.Error("a complete book fails the validator")
+		}
+	})
+}
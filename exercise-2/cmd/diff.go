@@ -0,0 +1,55 @@
+package main
+
+import (
+	"slices"
+)
+
+type DiffDTO struct {
+	A string `json:"a"`
+	B string `json:"b"`
+}
+
+type FieldDiff struct {
+	Field string      `json:"field"`
+	A     interface{} `json:"a"`
+	B     interface{} `json:"b"`
+}
+
+type BookDiff struct {
+	A           string      `json:"a"`
+	B           string      `json:"b"`
+	Differences []FieldDiff `json:"differences"`
+}
+
+// Compares the data of two books field by field, in the order of BookDTO,
+// and lists the fields whose values differ. The id and timestamps always
+// differ and are left out. ISBNs written with and without hyphens count as
+// the same.
+func diffBooks(a BookStore, b BookStore) BookDiff {
+	diff := BookDiff{A: a.ID.Hex(), B: b.ID.Hex(), Differences: make([]FieldDiff, 0)}
+	add := func(field string, valueA interface{}, valueB interface{}) {
+		diff.Differences = append(diff.Differences, FieldDiff{Field: field, A: valueA, B: valueB})
+	}
+	if a.BookName != b.BookName {
+		add("name", a.BookName, b.BookName)
+	}
+	if a.BookAuthor != b.BookAuthor {
+		add("author", a.BookAuthor, b.BookAuthor)
+	}
+	if a.BookPages != b.BookPages {
+		add("pages", a.BookPages, b.BookPages)
+	}
+	if a.BookYear != b.BookYear {
+		add("year", a.BookYear, b.BookYear)
+	}
+	if isbnKey(a.BookISBN) != isbnKey(b.BookISBN) {
+		add("isbn", a.BookISBN, b.BookISBN)
+	}
+	if !slices.Equal(a.Tags, b.Tags) {
+		add("tags", a.Tags, b.Tags)
+	}
+	if a.CoverURL != b.CoverURL {
+		add("coverUrl", a.CoverURL, b.CoverURL)
+	}
+	return diff
+}
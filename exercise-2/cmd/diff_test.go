@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// Two printings of the same book by the same author only differ in pages
+func TestDiffBooks(t *testing.T) {
+	books := seedBooks()
+	a := books[1]
+	b := books[1]
+	b.ID = primitive.NewObjectID()
+	b.BookISBN = "9783649646099"
+	b.BookPages = 304
+
+	got := diffBooks(a, b)
+	want := BookDiff{
+		A:           a.ID.Hex(),
+		B:           b.ID.Hex(),
+		Differences: []FieldDiff{{Field: "pages", A: 280, B: 304}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("diffBooks = %+v, want %+v", got, want)
+	}
+}
+
+func TestDiffRoute(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		books := seedBooks()
+		a := books[1]
+		b := books[1]
+		b.ID = primitive.NewObjectID()
+		b.BookPages = 304
+
+		mt.AddMockResponses(booksReply(t, a), booksReply(t, b))
+		rec := serve(e, http.MethodPost, "/api/books/diff", fmt.Sprintf(`{"a":%q,"b":%q}`, a.ID.Hex(), b.ID.Hex()))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+		var diff BookDiff
+		if err := json.Unmarshal(rec.Body.Bytes(), &diff); err != nil {
+			t.Fatal(err)
+		}
+		want := []FieldDiff{{Field: "pages", A: float64(280), B: float64(304)}}
+		if !reflect.DeepEqual(diff.Differences, want) {
+			t.Errorf("differences %+v, want %+v", diff.Differences, want)
+		}
+
+		// The second book is gone
+		mt.AddMockResponses(booksReply(t, a), booksReply(t))
+		rec = serve(e, http.MethodPost, "/api/books/diff", fmt.Sprintf(`{"a":%q,"b":%q}`, a.ID.Hex(), primitive.NewObjectID().Hex()))
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("status = %d, want 404: %s", rec.Code, rec.Body.String())
+		}
+		if detail := decodeEnvelope(t, rec.Body.Bytes()); detail.Code != codeNotFound {
+			t.Errorf("code = %q, want %q", detail.Code, codeNotFound)
+		}
+	})
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Machine readable codes sent along with every error, so clients do not
+// have to parse the human readable message
+const (
+	codeInvalidID            = "invalid_id"
+	codeInvalidPayload       = "invalid_payload"
+	codeInvalidQuery         = "invalid_query"
+	codeUnauthorized         = "unauthorized"
+	codeForbidden            = "forbidden"
+	codeNotReady             = "not_ready"
+	codeConfirmationRequired = "confirmation_required"
+	codeNotFound             = "not_found"
+	codeNotAcceptable        = "not_acceptable"
+	codeMethodNotAllowed     = "method_not_allowed"
+	codeUnsupportedMedia     = "unsupported_media_type"
+	codeConflict             = "conflict"
+	codePreconditionFailed   = "precondition_failed"
+	codeInternal             = "internal_error"
+	codeTimeout              = "timeout"
+)
+
+type ErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Same as the X-Request-Id header, to find the request in the logs
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// Every error leaves the API as {"error": {"code": "...", "message": "..."}}
+type ErrorEnvelope struct {
+	Error ErrorDetail `json:"error"`
+}
+
+// Writes the error envelope with the given HTTP status
+func respondError(c echo.Context, status int, code string, message string) error {
+	return c.JSON(status, ErrorEnvelope{
+		Error: ErrorDetail{
+			Code:      code,
+			Message:   message,
+			RequestID: c.Response().Header().Get(echo.HeaderXRequestID),
+		},
+	})
+}
+
+// The envelope as raw JSON, for middleware that writes the body itself
+func errorJSON(code string, message string) string {
+	body, _ := json.Marshal(ErrorEnvelope{
+		Error: ErrorDetail{
+			Code:    code,
+			Message: message,
+		},
+	})
+	return string(body)
+}
+
+// Picks the code for errors raised by echo itself, e.g. for unknown routes
+func codeForStatus(status int) string {
+	switch status {
+	case http.StatusNotFound:
+		return codeNotFound
+	case http.StatusMethodNotAllowed:
+		return codeMethodNotAllowed
+	case http.StatusUnauthorized:
+		return codeUnauthorized
+	case http.StatusForbidden:
+		return codeForbidden
+	case http.StatusNotAcceptable:
+		return codeNotAcceptable
+	case http.StatusUnsupportedMediaType:
+		return codeUnsupportedMedia
+	case http.StatusServiceUnavailable:
+		return codeTimeout
+	}
+	if status >= http.StatusInternalServerError {
+		return codeInternal
+	}
+	return codeInvalidPayload
+}
+
+// Handles the errors our handlers do not answer themselves, like unknown
+// routes. Below /api they get the JSON envelope, pages get the not-found
+// template and everything else goes to the given fallback.
+func httpErrorHandler(fallback echo.HTTPErrorHandler) echo.HTTPErrorHandler {
+	return func(err error, c echo.Context) {
+		if c.Response().Committed {
+			return
+		}
+		status := http.StatusInternalServerError
+		message := http.StatusText(status)
+		var httpErr *echo.HTTPError
+		if errors.As(err, &httpErr) {
+			status = httpErr.Code
+			message = http.StatusText(status)
+			// Only plain messages are passed on, internal errors never are
+			if text, ok := httpErr.Message.(string); ok && status < http.StatusInternalServerError {
+				message = text
+			}
+		}
+
+		// The router has already put the methods the route supports into
+		// the Allow header, tell the client about them too
+		if allow := c.Response().Header().Get(echo.HeaderAllow); status == http.StatusMethodNotAllowed && allow != "" {
+			message = fmt.Sprintf("%s is not supported here, use one of %s", c.Request().Method, allow)
+		}
+
+		path := c.Request().URL.Path
+		if path == "/api" || strings.HasPrefix(path, "/api/") {
+			if c.Request().Method == http.MethodHead {
+				err = c.NoContent(status)
+			} else {
+				err = respondError(c, status, codeForStatus(status), message)
+			}
+		} else if status == http.StatusNotFound {
+			err = c.Render(http.StatusNotFound, "not-found", "This page does not exist.")
+		} else {
+			fallback(err, c)
+			return
+		}
+		if err != nil {
+			c.Logger().Error(err)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// Decodes the error envelope of a response, failing the test when the body
+// is not one
+func decodeEnvelope(t *testing.T, body []byte) ErrorDetail {
+	t.Helper()
+	var envelope ErrorEnvelope
+	if err := json.Unmarshal(body, &envelope); err != nil || envelope.Error.Code == "" {
+		t.Fatalf("body %s is not an error envelope (%v)", body, err)
+	}
+	return envelope.Error
+}
+
+func TestMalformedPostEnvelope(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+
+		rec := serve(e, http.MethodPost, "/api/books", `{"name": "Frankenstein",`)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want 400", rec.Code)
+		}
+		if got := decodeEnvelope(t, rec.Body.Bytes()); got.Code != codeInvalidPayload || got.Message == "" {
+			t.Errorf("got %+v, want code %s with a message", got, codeInvalidPayload)
+		}
+	})
+}
+
+func TestUnknownAPIRoute(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+
+		rec := serve(e, http.MethodGet, "/api/nonexistent", "")
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("status = %d, want 404", rec.Code)
+		}
+		if contentType := rec.Header().Get(echo.HeaderContentType); !strings.HasPrefix(contentType, echo.MIMEApplicationJSON) {
+			t.Errorf("content type %q, want JSON", contentType)
+		}
+		if got := decodeEnvelope(t, rec.Body.Bytes()); got.Code != codeNotFound {
+			t.Errorf("got %+v, want code %s", got, codeNotFound)
+		}
+	})
+}
+
+// A method the route does not have is answered with 405 and the methods it
+// does have
+func TestUnsupportedMethod(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+
+		rec := serve(e, http.MethodPatch, "/api/books", `{}`)
+		if rec.Code != http.StatusMethodNotAllowed {
+			t.Fatalf("status = %d, want 405: %s", rec.Code, rec.Body.String())
+		}
+		allow := rec.Header().Get(echo.HeaderAllow)
+		for _, method := range []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodDelete} {
+			if !strings.Contains(allow, method) {
+				t.Errorf("Allow = %q, want it to list %s", allow, method)
+			}
+		}
+		if strings.Contains(allow, http.MethodPatch) {
+			t.Errorf("Allow = %q lists PATCH", allow)
+		}
+		if got := decodeEnvelope(t, rec.Body.Bytes()); got.Code != codeMethodNotAllowed || !strings.Contains(got.Message, allow) {
+			t.Errorf("got %+v, want code %s naming %s", got, codeMethodNotAllowed, allow)
+		}
+	})
+}
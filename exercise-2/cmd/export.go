@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Writes every book as one JSON array, decoding them from the cursor one at
+// a time, so the memory used stays the same no matter how big the catalog
+// is. With pretty, the output is indented for humans.
+func streamBooksJSON(w io.Writer, coll *mongo.Collection, pretty bool) error {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := coll.Find(context.TODO(), bson.D{}, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(context.TODO())
+
+	start, separator, end := "[", ",", "]"
+	if pretty {
+		start, separator, end = "[\n  ", ",\n  ", "\n]\n"
+	}
+	if _, err = io.WriteString(w, start); err != nil {
+		return err
+	}
+	for i := 0; cursor.Next(context.TODO()); i++ {
+		var book BookStore
+		if err = cursor.Decode(&book); err != nil {
+			return err
+		}
+		var data []byte
+		if pretty {
+			data, err = json.MarshalIndent(book.ToDTO(), "  ", "  ")
+		} else {
+			data, err = json.Marshal(book.ToDTO())
+		}
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			if _, err = io.WriteString(w, separator); err != nil {
+				return err
+			}
+		}
+		if _, err = w.Write(data); err != nil {
+			return err
+		}
+	}
+	if err = cursor.Err(); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, end)
+	return err
+}
+
+type RestoreSummary struct {
+	Restored int `json:"restored"`
+	Skipped  int `json:"skipped"`
+	// Books that fail the checks of the create path, by their position in
+	// the backup. They are counted as skipped too.
+	Invalid []SkippedBook `json:"invalid,omitempty"`
+}
+
+// Inserts books from a backup made by streamBooksJSON. Books keep their
+// original id and timestamps; those whose id is already taken are skipped,
+// so restoring the same backup twice is harmless. Every book has to pass
+// validate (the struct tags, i.e. c.Validate) and validateBook like a new
+// one, a backup edited by hand must not get around them.
+func restoreBooks(coll *mongo.Collection, books []BookDTO, limits BookLimits, validate func(i interface{}) error) (RestoreSummary, error) {
+	summary := RestoreSummary{}
+	invalid := func(i int, errs []FieldError) {
+		summary.Invalid = append(summary.Invalid, SkippedBook{Index: i, Errors: errs})
+		summary.Skipped++
+	}
+	docs := make([]interface{}, 0, len(books))
+	for i, book := range books {
+		post := PostBookDTO{
+			Name:     book.Name,
+			Author:   book.Author,
+			Pages:    book.Pages,
+			Year:     book.Year,
+			Isbn:     book.Isbn,
+			Tags:     book.Tags,
+			CoverURL: book.CoverURL,
+		}
+		if err := validate(&post); err != nil {
+			invalid(i, fieldErrors(err))
+			continue
+		}
+		bookStore := post.ToStore()
+		if errs := validateBook(bookStore, limits); errs != nil {
+			invalid(i, errs)
+			continue
+		}
+		if book.Id != "" {
+			id, err := primitive.ObjectIDFromHex(book.Id)
+			if err != nil {
+				invalid(i, []FieldError{{Field: "id", Message: "must be a book id"}})
+				continue
+			}
+			bookStore.ID = id
+		}
+		if book.CreatedAt != nil {
+			bookStore.CreatedAt = *book.CreatedAt
+		}
+		if book.UpdatedAt != nil {
+			bookStore.UpdatedAt = *book.UpdatedAt
+		}
+		docs = append(docs, bookStore)
+	}
+	if len(docs) == 0 {
+		return summary, nil
+	}
+
+	// Unordered, so an existing id does not stop the rest
+	_, err := coll.InsertMany(context.TODO(), docs, options.InsertMany().SetOrdered(false))
+	var writeErr mongo.BulkWriteException
+	if errors.As(err, &writeErr) && writeErr.WriteConcernError == nil {
+		summary.Skipped += len(writeErr.WriteErrors)
+		summary.Restored = len(docs) - len(writeErr.WriteErrors)
+		return summary, nil
+	}
+	if err != nil {
+		return summary, err
+	}
+	summary.Restored = len(docs)
+	return summary, nil
+}
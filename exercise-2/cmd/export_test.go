@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestExportJSON(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+
+		for _, query := range []string{"", "?pretty=true"} {
+			mt.AddMockResponses(booksReply(t, seedBooks()...))
+			rec := serve(e, http.MethodGet, "/api/books/export.json"+query, "")
+			var books []BookDTO
+			if err := json.Unmarshal(rec.Body.Bytes(), &books); err != nil {
+				t.Fatalf("export%s is not valid JSON: %v\n%s", query, err, rec.Body.String())
+			}
+			if len(books) != 3 {
+				t.Errorf("export%s holds %d books, want 3", query, len(books))
+			}
+		}
+	})
+}
+
+func TestExportJSONEmpty(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+
+		mt.AddMockResponses(booksReply(t))
+		if body := serve(e, http.MethodGet, "/api/books/export.json", "").Body.String(); strings.TrimSpace(body) != "[]" {
+			t.Errorf("export of no books = %q, want []", body)
+		}
+	})
+}
+
+func TestExportAndRestore(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		books := seedBooks()
+		books[1].Tags = []string{"gothic"}
+
+		mt.AddMockResponses(booksReply(t, books...))
+		backup := serve(e, http.MethodGet, "/api/books/export.json", "").Body.String()
+
+		// Restoring into an empty collection inserts every book as it was
+		mt.AddMockResponses(writeReply(3))
+		rec := serve(e, http.MethodPost, "/api/books/restore", backup)
+		if rec.Code != http.StatusOK || rec.Body.String() != `{"restored":3,"skipped":0}`+"\n" {
+			t.Fatalf("got %d %s, want three books restored", rec.Code, rec.Body.String())
+		}
+		docs, err := sentCommands(mt, "insert")[0].Command.Lookup("documents").Array().Values()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, doc := range docs {
+			var restored BookStore
+			if err := doc.Unmarshal(&restored); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(restored, books[i]) {
+				t.Errorf("restored %+v, want %+v", restored, books[i])
+			}
+		}
+	})
+}
+
+// A backup edited by hand goes through the same checks as a new book
+func TestRestoreRejectsInvalidBooks(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		books := seedBooks()
+		backup := `[
+			{"id":"` + books[0].ID.Hex() + `","name":"The Vortex","author":"José Eustasio Rivera","pages":292,"year":1924},
+			{"id":"` + books[1].ID.Hex() + `","name":"Frankenstein","author":"","pages":280,"year":1818},
+			{"id":"` + books[2].ID.Hex() + `","name":"The Black Cat","author":"Edgar Allan Poe","pages":-1,"year":1843},
+			{"id":"not-an-id","name":"The Last Man","author":"Mary Shelley"}
+		]`
+
+		mt.AddMockResponses(writeReply(1))
+		rec := serve(e, http.MethodPost, "/api/books/restore", backup)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+		var summary RestoreSummary
+		if err := json.Unmarshal(rec.Body.Bytes(), &summary); err != nil {
+			t.Fatal(err)
+		}
+		if summary.Restored != 1 || summary.Skipped != 3 {
+			t.Errorf("got %+v, want 1 restored and 3 skipped", summary)
+		}
+		var positions []int
+		for _, skipped := range summary.Invalid {
+			positions = append(positions, skipped.Index)
+		}
+		if !reflect.DeepEqual(positions, []int{1, 2, 3}) {
+			t.Errorf("invalid books %v, want 1, 2 and 3", summary.Invalid)
+		}
+		docs, err := sentCommands(mt, "insert")[0].Command.Lookup("documents").Array().Values()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(docs) != 1 {
+			t.Errorf("inserted %d books, want only the valid one", len(docs))
+		}
+	})
+}
+
+func TestRestoreTooManyBooks(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+
+		backup := "[" + strings.Repeat(`{"name":"Frankenstein","author":"Mary Shelley"},`, maxImportBooks) + `{"name":"Frankenstein","author":"Mary Shelley"}]`
+		if rec := serve(e, http.MethodPost, "/api/books/restore", backup); rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want 400", rec.Code)
+		}
+		if inserts := sentCommands(mt, "insert"); len(inserts) != 0 {
+			t.Errorf("sent %d inserts for a backup over the limit", len(inserts))
+		}
+	})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/labstack/echo/v4"
+)
+
+const flashCookie = "flash"
+
+// Remembers a one-off message (e.g. "Book added") for the next page the
+// browser renders. We keep it in a short-lived cookie, so no session store
+// is needed.
+func setFlash(c echo.Context, message string) {
+	c.SetCookie(&http.Cookie{
+		Name:     flashCookie,
+		Value:    url.QueryEscape(message),
+		Path:     "/",
+		MaxAge:   60,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Returns the pending flash message, if any, and clears the cookie so the
+// message is shown only once.
+func popFlash(c echo.Context) string {
+	cookie, err := c.Cookie(flashCookie)
+	if err != nil {
+		return ""
+	}
+	c.SetCookie(&http.Cookie{
+		Name:     flashCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	message, err := url.QueryUnescape(cookie.Value)
+	if err != nil {
+		return ""
+	}
+	return message
+}
+
+// Data passed to the "book-table" template. Sort and Order are the
+// ?sort= and ?order= the table was sorted with, if any.
+type BookTable struct {
+	Flash string
+	Books []map[string]interface{}
+	Sort  string
+	Order string
+}
+
+// Order the header link of the given column asks for: a click on the column
+// the table is sorted by in ascending order reverses it, any other click
+// sorts ascending
+func (t BookTable) NextOrder(field string) string {
+	if t.Sort == field && t.Order != "desc" {
+		return "desc"
+	}
+	return "asc"
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestFlashAfterCreate(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+
+		mt.AddMockResponses(writeReply(1))
+		rec := postForm(e, url.Values{"name": {"The Last Man"}, "author": {"Mary Shelley"}})
+		var flash *http.Cookie
+		for _, cookie := range rec.Result().Cookies() {
+			if cookie.Name == flashCookie {
+				flash = cookie
+			}
+		}
+		if flash == nil || flash.MaxAge <= 0 {
+			t.Fatalf("cookies %v, want the flash cookie", rec.Result().Cookies())
+		}
+
+		// The next page shows the message and clears the cookie
+		mt.AddMockResponses(booksReply(t))
+		rec = serve(e, http.MethodGet, "/books", "", "Cookie", flash.Name+"="+flash.Value)
+		if !strings.Contains(rec.Body.String(), "Book added") {
+			t.Errorf("the message is not shown:\n%s", rec.Body.String())
+		}
+		cleared := rec.Result().Cookies()
+		if len(cleared) != 1 || cleared[0].Name != flashCookie || cleared[0].MaxAge >= 0 {
+			t.Errorf("cookies %v, want the flash cookie cleared", cleared)
+		}
+
+		// And the one after that does not
+		mt.AddMockResponses(booksReply(t))
+		if body := serve(e, http.MethodGet, "/books", "").Body.String(); strings.Contains(body, "Book added") {
+			t.Errorf("the message is shown twice:\n%s", body)
+		}
+	})
+}
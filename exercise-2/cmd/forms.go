@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Data passed to the "create-form" template. Values keeps what the user
+// typed, so the form can be shown again with the inline errors next to
+// the offending fields.
+type CreateForm struct {
+	Values map[string]string
+	Errors map[string]string
+}
+
+// Reads the create form (application/x-www-form-urlencoded, not JSON) into
+// a book. Numbers that cannot be parsed are reported like any other
+// validation error.
+func bookFromForm(c echo.Context, limits BookLimits) (PostBookDTO, CreateForm) {
+	form := CreateForm{Values: map[string]string{}, Errors: map[string]string{}}
+	for _, field := range []string{"name", "author", "isbn", "pages", "year"} {
+		form.Values[field] = strings.TrimSpace(c.FormValue(field))
+	}
+
+	book := PostBookDTO{
+		Name:   form.Values["name"],
+		Author: form.Values["author"],
+		Isbn:   form.Values["isbn"],
+	}
+	var err error
+	if form.Values["pages"] != "" {
+		if book.Pages, err = strconv.Atoi(form.Values["pages"]); err != nil {
+			form.Errors["pages"] = "must be a number"
+		}
+	}
+	if form.Values["year"] != "" {
+		if book.Year, err = strconv.Atoi(form.Values["year"]); err != nil {
+			form.Errors["year"] = "must be a number"
+		}
+	}
+
+	for _, fieldErr := range validateBook(book.ToStore(), limits) {
+		if _, ok := form.Errors[fieldErr.Field]; !ok {
+			form.Errors[fieldErr.Field] = fieldErr.Message
+		}
+	}
+	return book, form
+}
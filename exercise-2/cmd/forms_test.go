@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// Submits the create form like a browser does
+func postForm(e *echo.Echo, values url.Values) *httptest.ResponseRecorder {
+	return serve(e, http.MethodPost, "/create", values.Encode(), echo.HeaderContentType, echo.MIMEApplicationForm)
+}
+
+func TestCreateFormRedirects(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+
+		mt.AddMockResponses(writeReply(1))
+		rec := postForm(e, url.Values{
+			"name": {"The Last Man"}, "author": {"Mary Shelley"}, "pages": {"479"}, "year": {"1826"},
+		})
+		if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/books" {
+			t.Fatalf("got %d to %q, want a 303 to /books", rec.Code, rec.Header().Get("Location"))
+		}
+
+		// The list then holds the stored book
+		var stored BookStore
+		if err := bson.Unmarshal(insertedDoc(t, mt, 0), &stored); err != nil {
+			t.Fatal(err)
+		}
+		mt.AddMockResponses(booksReply(t, stored))
+		if body := serve(e, http.MethodGet, "/books", "").Body.String(); !strings.Contains(body, "The Last Man") {
+			t.Errorf("the new book is not listed:\n%s", body)
+		}
+	})
+}
+
+func TestCreateFormErrors(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+
+		rec := postForm(e, url.Values{"name": {"The Last Man"}, "author": {"Mary Shelley"}, "pages": {"many"}})
+		if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "must be a number") {
+			t.Errorf("got %d, want 422 with the inline error:\n%s", rec.Code, rec.Body.String())
+		}
+		if inserts := sentCommands(mt, "insert"); len(inserts) != 0 {
+			t.Errorf("an invalid form sent %d inserts", len(inserts))
+		}
+	})
+}
+
+func TestCreateFormDuplicateISBN(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+
+		mt.AddMockResponses(duplicateKeyReply())
+		rec := postForm(e, url.Values{
+			"name": {"Frankenstein"}, "author": {"Mary Shelley"}, "isbn": {"978-3-649-64609-9"},
+		})
+		if rec.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("status = %d, want 422:\n%s", rec.Code, rec.Body.String())
+		}
+		if body := rec.Body.String(); !strings.Contains(body, "ISBN is already used by another book") {
+			t.Errorf("the form lacks the inline ISBN error:\n%s", body)
+		}
+	})
+}
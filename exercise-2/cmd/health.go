@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// Set once the indexes of the books collection are in place. The migrations
+// run before the server starts, so they are done by the time anyone asks.
+// A failed index build at startup is retried with POST /api/admin/reindex.
+var indexesReady atomic.Bool
+
+// How long /readyz waits for MongoDB to answer, short enough for the
+// timeout of a probe
+const readinessTimeout = 2 * time.Second
+
+// Reports why we cannot serve requests right now, or nil when we can
+func checkReadiness(client *mongo.Client) error {
+	if !indexesReady.Load() {
+		return fmt.Errorf("the book indexes are not ready")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), readinessTimeout)
+	defer cancel()
+	if err := client.Ping(ctx, readpref.Primary()); err != nil {
+		return fmt.Errorf("MongoDB is not reachable")
+	}
+	return nil
+}
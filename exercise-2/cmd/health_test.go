@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// Without the indexes we are alive but not ready, and /readyz does not even
+// ask the database
+func TestReadyzWithoutIndexes(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		defer indexesReady.Store(indexesReady.Load())
+		indexesReady.Store(false)
+
+		if rec := serve(e, http.MethodGet, "/livez", ""); rec.Code != http.StatusOK {
+			t.Errorf("livez: status = %d, want 200", rec.Code)
+		}
+		rec := serve(e, http.MethodGet, "/readyz", "")
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Fatalf("readyz: status = %d, want 503: %s", rec.Code, rec.Body.String())
+		}
+		detail := decodeEnvelope(t, rec.Body.Bytes())
+		if detail.Code != codeNotReady || !strings.Contains(detail.Message, "indexes") {
+			t.Errorf("got %+v, want %q naming the indexes", detail, codeNotReady)
+		}
+		if started := mt.GetAllStartedEvents(); len(started) != 0 {
+			t.Errorf("sent %d commands, want none", len(started))
+		}
+	})
+}
+
+// A database outage makes us not ready, but not dead, so we are not
+// restarted for it
+func TestReadyzWhileMongoIsDown(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		defer indexesReady.Store(indexesReady.Load())
+		indexesReady.Store(true)
+
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 91, Message: "shutdown in progress"}))
+		rec := serve(e, http.MethodGet, "/readyz", "")
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Fatalf("readyz: status = %d, want 503: %s", rec.Code, rec.Body.String())
+		}
+		if detail := decodeEnvelope(t, rec.Body.Bytes()); detail.Code != codeNotReady || !strings.Contains(detail.Message, "MongoDB") {
+			t.Errorf("got %+v, want %q naming MongoDB", detail, codeNotReady)
+		}
+		if rec := serve(e, http.MethodGet, "/livez", ""); rec.Code != http.StatusOK {
+			t.Errorf("livez: status = %d, want 200", rec.Code)
+		}
+
+		// Once the ping is answered again, we are ready
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		if rec := serve(e, http.MethodGet, "/readyz", ""); rec.Code != http.StatusOK {
+			t.Errorf("readyz: status = %d after a successful ping, want 200", rec.Code)
+		}
+	})
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The tests run from the module root, where the views and static files are,
+// just like the server does
+func TestMain(m *testing.M) {
+	if err := os.Chdir(".."); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// Runs the test against a mock deployment instead of a real MongoDB. The
+// database answers every command with the next reply queued through
+// mt.AddMockResponses, and the commands we sent can be inspected afterwards
+// with sentCommands. The test runs as a subtest, so it gets the subtest's t.
+func withMockDB(t *testing.T, test func(t *testing.T, mt *mtest.T)) {
+	mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock)).Run("mock", func(mt *mtest.T) {
+		test(mt.T, mt)
+	})
+}
+
+// A repository on the mock deployment with the default settings of main
+func testRepo(mt *mtest.T) BookRepository {
+	return BookRepository{
+		Books:               mt.Coll,
+		ReadBooks:           mt.Coll,
+		History:             mt.DB.Collection("book_history"),
+		IdempotencyKeys:     mt.DB.Collection("idempotency_keys"),
+		AuthorStats:         newAuthorStatsCache(mt.Coll),
+		Limits:              BookLimits{MaxPages: 10000},
+		PageSizes:           PageSizes{Default: 20, Max: 100},
+		Collation:           &options.Collation{Locale: "en"},
+		MaxResults:          10000,
+		ImportDefaultAuthor: defaultImportAuthor,
+	}
+}
+
+// The server as main sets it up, minus the global middleware
+func newTestServer(repo BookRepository) *echo.Echo {
+	e := echo.New()
+	e.Renderer = loadTemplates(false)
+	e.Validator = newBookValidator()
+	e.HTTPErrorHandler = httpErrorHandler(e.DefaultHTTPErrorHandler)
+	return registerRoutes(e, repo)
+}
+
+// Sends a request to the server. A body is sent as JSON unless a
+// Content-Type is given in the headers, which come as name, value pairs.
+func serve(e *echo.Echo, method string, target string, body string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+// The sample books of prepareData, with fixed ids
+func seedBooks() []BookStore {
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	books := []BookStore{
+		{BookName: "The Vortex", BookAuthor: "José Eustasio Rivera", BookISBN: "958-30-0804-4", BookPages: 292, BookYear: 1924},
+		{BookName: "Frankenstein", BookAuthor: "Mary Shelley", BookISBN: "978-3-649-64609-9", BookPages: 280, BookYear: 1818},
+		{BookName: "The Black Cat", BookAuthor: "Edgar Allan Poe", BookISBN: "978-3-99168-238-7", BookPages: 280, BookYear: 1843},
+	}
+	for i := range books {
+		books[i].ID = primitive.NewObjectIDFromTimestamp(created.Add(time.Duration(i) * time.Second))
+		books[i].BookAuthorLower = authorKey(books[i].BookAuthor)
+		books[i].BookISBNDigits = isbnKey(books[i].BookISBN)
+		books[i].CreatedAt = created
+		books[i].UpdatedAt = created
+	}
+	return books
+}
+
+// Converts anything bson can encode, like a BookStore, into a document for
+// a mock reply
+func toDoc(t *testing.T, v interface{}) bson.D {
+	t.Helper()
+	data, err := bson.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc bson.D
+	if err = bson.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+// Reply to a find or aggregate returning the given documents in one batch
+func cursorReply(t *testing.T, docs ...interface{}) bson.D {
+	t.Helper()
+	batch := make([]bson.D, 0, len(docs))
+	for _, doc := range docs {
+		batch = append(batch, toDoc(t, doc))
+	}
+	return mtest.CreateCursorResponse(0, "test.books", mtest.FirstBatch, batch...)
+}
+
+// Reply to a find returning the given books
+func booksReply(t *testing.T, books ...BookStore) bson.D {
+	t.Helper()
+	docs := make([]interface{}, 0, len(books))
+	for _, book := range books {
+		docs = append(docs, book)
+	}
+	return cursorReply(t, docs...)
+}
+
+// Reply to CountDocuments, which the driver runs as an aggregation
+func countReply(t *testing.T, n int) bson.D {
+	t.Helper()
+	if n == 0 {
+		return cursorReply(t)
+	}
+	return cursorReply(t, bson.M{"n": n})
+}
+
+// Reply to a successful insert, update or delete of n documents
+func writeReply(n int) bson.D {
+	return mtest.CreateSuccessResponse(bson.E{Key: "n", Value: n}, bson.E{Key: "nModified", Value: n})
+}
+
+// Reply to a findAndModify, i.e. FindOne* returning the given document, or
+// no document with nil
+func findAndModifyReply(t *testing.T, doc interface{}) bson.D {
+	t.Helper()
+	if doc == nil {
+		return mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil})
+	}
+	return mtest.CreateSuccessResponse(bson.E{Key: "value", Value: toDoc(t, doc)})
+}
+
+// Reply rejecting a write because of a unique index
+func duplicateKeyReply() bson.D {
+	return mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 0, Code: 11000, Message: "E11000 duplicate key error"})
+}
+
+// The commands with the given name (find, insert, ...) the test sent so far
+func sentCommands(mt *mtest.T, name string) []*event.CommandStartedEvent {
+	var commands []*event.CommandStartedEvent
+	for _, started := range mt.GetAllStartedEvents() {
+		if started.CommandName == name {
+			commands = append(commands, started)
+		}
+	}
+	return commands
+}
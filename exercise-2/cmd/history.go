@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Actions recorded in the history of a book
+const (
+	historyUpdate = "update"
+	historyDelete = "delete"
+)
+
+// A snapshot of a book taken right before it was updated or deleted. They
+// live in their own collection (book_history), so the books stay small.
+type BookHistory struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	BookID    primitive.ObjectID `bson:"bookid"`
+	Action    string             `bson:"action"`
+	ChangedAt time.Time          `bson:"changedat"`
+	Previous  BookStore          `bson:"previous"`
+}
+
+type BookHistoryDTO struct {
+	Action    string    `json:"action"`
+	ChangedAt time.Time `json:"changedAt"`
+	Previous  BookDTO   `json:"previous"`
+}
+
+// Stores the previous version of a book. A failure is only logged, since the
+// change itself already happened and should still be reported as done.
+func recordHistory(history *mongo.Collection, action string, previous BookStore) {
+	entry := BookHistory{
+		BookID:    previous.ID,
+		Action:    action,
+		ChangedAt: time.Now().UTC(),
+		Previous:  previous,
+	}
+	if _, err := history.InsertOne(context.TODO(), entry); err != nil {
+		log.Printf("warning: failed to record %s of book %s: %v\n", action, previous.ID.Hex(), err)
+	}
+}
+
+// Returns the prior versions of a book, oldest first
+func findHistory(history *mongo.Collection, bookID primitive.ObjectID) ([]BookHistoryDTO, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "changedat", Value: 1}, {Key: "_id", Value: 1}})
+	filter := bson.M{"bookid": bookID}
+	defer timeQuery(history, "find", filter)()
+	cursor, err := history.Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var entries []BookHistory
+	if err = cursor.All(context.TODO(), &entries); err != nil {
+		return nil, err
+	}
+
+	ret := make([]BookHistoryDTO, 0, len(entries))
+	for _, entry := range entries {
+		ret = append(ret, BookHistoryDTO{
+			Action:    entry.Action,
+			ChangedAt: entry.ChangedAt,
+			Previous:  entry.Previous.ToDTO(),
+		})
+	}
+	return ret, nil
+}
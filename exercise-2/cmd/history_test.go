@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestHistoryOfTwoUpdates(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		book := seedBooks()[1]
+
+		for _, pages := range []int{290, 300} {
+			mt.AddMockResponses(findAndModifyReply(t, book), writeReply(1))
+			payload := `{"name":"Frankenstein","author":"Mary Shelley","pages":` + strconv.Itoa(pages) + `,"year":1818}`
+			if rec := serve(e, http.MethodPut, "/api/books/"+book.ID.Hex(), payload); rec.Code != http.StatusOK {
+				t.Fatalf("update: status = %d, want 200: %s", rec.Code, rec.Body.String())
+			}
+			book.BookPages = pages
+		}
+
+		// The entries recorded, in the order the database hands them back
+		var entries []interface{}
+		for _, insert := range sentCommands(mt, "insert") {
+			if insert.Command.Lookup("insert").StringValue() != "book_history" {
+				continue
+			}
+			entries = append(entries, insert.Command.Lookup("documents").Array().Index(0).Value().Document())
+		}
+		if len(entries) != 2 {
+			t.Fatalf("recorded %d history entries, want 2", len(entries))
+		}
+
+		mt.AddMockResponses(cursorReply(t, entries...))
+		rec := serve(e, http.MethodGet, "/api/books/"+book.ID.Hex()+"/history", "")
+		var history []BookHistoryDTO
+		if err := json.Unmarshal(rec.Body.Bytes(), &history); err != nil {
+			t.Fatalf("body %s: %v", rec.Body.String(), err)
+		}
+		if len(history) != 2 || history[0].Previous.Pages != 280 || history[1].Previous.Pages != 290 {
+			t.Errorf("history = %+v, want the versions with 280 and 290 pages", history)
+		}
+		filter := sentCommands(mt, "find")[0].Command.Lookup("filter")
+		if id := filter.Document().Lookup("bookid").ObjectID(); id != book.ID {
+			t.Errorf("history of %s, want %s", id.Hex(), book.ID.Hex())
+		}
+	})
+}
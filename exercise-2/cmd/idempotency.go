@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const headerIdempotencyKey = "Idempotency-Key"
+
+// An Idempotency-Key together with the book it created. The book is missing
+// while the first request with the key is still being processed. MongoDB
+// removes the entry once it is older than the TTL of the collection.
+type IdempotencyKey struct {
+	Key       string     `bson:"_id"`
+	Book      *BookStore `bson:"book,omitempty"`
+	CreatedAt time.Time  `bson:"createdat"`
+}
+
+// Returns the collection remembering processed Idempotency-Keys. The TTL
+// index lets the database expire keys by itself after the given time.
+// More on TTL indexes: https://www.mongodb.com/docs/manual/core/index-ttl/
+func prepareIdempotencyKeys(db *mongo.Database, ttl time.Duration) (*mongo.Collection, error) {
+	keys := db.Collection("idempotency_keys")
+	_, err := keys.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "createdat", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(ttl.Seconds())),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Claims the key for the current request before anything is stored. The
+// key is the _id, so of two requests racing with the same key only one
+// insert succeeds. For the other one the earlier entry is returned, which
+// holds the book once the first request is done.
+func reserveIdempotencyKey(keys *mongo.Collection, key string) (*IdempotencyKey, error) {
+	entry := IdempotencyKey{Key: key, CreatedAt: time.Now().UTC()}
+	_, err := keys.InsertOne(context.TODO(), entry)
+	if err == nil {
+		return nil, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return nil, err
+	}
+	var previous IdempotencyKey
+	if err = keys.FindOne(context.TODO(), bson.M{"_id": key}).Decode(&previous); err != nil {
+		return nil, err
+	}
+	return &previous, nil
+}
+
+// Remembers the book created for a reserved key. A failure is only logged,
+// the book was stored anyway and a retry would be answered with a 409 until
+// the key expires.
+func completeIdempotencyKey(keys *mongo.Collection, key string, book BookStore) {
+	_, err := keys.UpdateOne(context.TODO(), bson.M{"_id": key}, bson.M{"$set": bson.M{"book": book}})
+	if err != nil {
+		log.Printf("warning: failed to store idempotency key: %v\n", err)
+	}
+}
+
+// Frees a reserved key when no book was created, so the client can retry
+func releaseIdempotencyKey(keys *mongo.Collection, key string) {
+	if _, err := keys.DeleteOne(context.TODO(), bson.M{"_id": key}); err != nil {
+		log.Printf("warning: failed to release idempotency key: %v\n", err)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+const frankensteinJSON = `{"name":"Frankenstein","author":"Mary Shelley","isbn":"978-3-649-64609-9","pages":280,"year":1818}`
+
+func TestIdempotentCreate(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+
+		// Reserving the key, looking for an existing copy, inserting the
+		// book and storing it with the key
+		mt.AddMockResponses(writeReply(1), booksReply(t), writeReply(1), writeReply(1))
+		first := serve(e, http.MethodPost, "/api/books", frankensteinJSON, headerIdempotencyKey, "retry-1")
+		if first.Code != http.StatusCreated {
+			t.Fatalf("first: status = %d, want 201: %s", first.Code, first.Body.String())
+		}
+		var created BookDTO
+		if err := json.Unmarshal(first.Body.Bytes(), &created); err != nil {
+			t.Fatal(err)
+		}
+
+		// The retry finds the key taken, with the book stored along
+		var book BookStore
+		completed := sentCommands(mt, "update")[0].Command.Lookup("updates").Array().Index(0).Value().Document()
+		if err := completed.Lookup("u", "$set", "book").Unmarshal(&book); err != nil {
+			t.Fatal(err)
+		}
+		entry := IdempotencyKey{Key: "retry-1", Book: &book, CreatedAt: time.Now().UTC()}
+		mt.AddMockResponses(duplicateKeyReply(), cursorReply(t, entry))
+		retry := serve(e, http.MethodPost, "/api/books", frankensteinJSON, headerIdempotencyKey, "retry-1")
+		var repeated BookDTO
+		if err := json.Unmarshal(retry.Body.Bytes(), &repeated); err != nil {
+			t.Fatal(err)
+		}
+		if retry.Code != http.StatusCreated || repeated.Id != created.Id {
+			t.Errorf("retry: got %d %s, want the book %s again", retry.Code, retry.Body.String(), created.Id)
+		}
+
+		books := 0
+		for _, insert := range sentCommands(mt, "insert") {
+			if insert.Command.Lookup("insert").StringValue() == mt.Coll.Name() {
+				books++
+			}
+		}
+		if books != 1 {
+			t.Errorf("inserted %d books, want 1", books)
+		}
+	})
+}
+
+func TestIdempotencyKeyInProgress(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+
+		// The first request holds the key but has not stored its book yet
+		entry := IdempotencyKey{Key: "retry-1", CreatedAt: time.Now().UTC()}
+		mt.AddMockResponses(duplicateKeyReply(), cursorReply(t, entry))
+		rec := serve(e, http.MethodPost, "/api/books", frankensteinJSON, headerIdempotencyKey, "retry-1")
+		if rec.Code != http.StatusConflict {
+			t.Errorf("status = %d, want 409: %s", rec.Code, rec.Body.String())
+		}
+		if deletes := sentCommands(mt, "delete"); len(deletes) != 0 {
+			t.Error("the key of the other request was released")
+		}
+	})
+}
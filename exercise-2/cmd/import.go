@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Limits for remote catalogs, so a single import cannot exhaust memory
+const (
+	maxImportBytes = 5 << 20
+	maxImportBooks = 1000
+)
+
+var errForbiddenAddress = errors.New("address is not allowed")
+
+// Reports whether the address points to ourselves or to a private network.
+// Such addresses must never be fetched on behalf of a client (SSRF).
+func isForbiddenIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast()
+}
+
+// HTTP client used to fetch remote catalogs. The address is checked right
+// before connecting, i.e. after DNS resolution, so neither a hostname
+// resolving to a private IP nor a redirect to one gets through.
+var importClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: func(network string, address string, conn syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				ip := net.ParseIP(host)
+				if ip == nil || isForbiddenIP(ip) {
+					return errForbiddenAddress
+				}
+				return nil
+			},
+		}).DialContext,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 5 {
+			return errors.New("too many redirects")
+		}
+		return checkImportURL(req.URL)
+	},
+}
+
+// Only plain http(s) URLs to public hosts may be imported
+func checkImportURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("only http and https URLs are allowed")
+	}
+	host := u.Hostname()
+	if host == "" {
+		return fmt.Errorf("the URL has no host")
+	}
+	if strings.EqualFold(host, "localhost") || strings.HasSuffix(strings.ToLower(host), ".localhost") {
+		return errForbiddenAddress
+	}
+	if ip := net.ParseIP(host); ip != nil && isForbiddenIP(ip) {
+		return errForbiddenAddress
+	}
+	return nil
+}
+
+// Downloads a JSON array of books from the given URL
+func fetchCatalog(rawURL string) ([]PostBookDTO, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL")
+	}
+	if err = checkImportURL(u); err != nil {
+		return nil, err
+	}
+
+	resp, err := importClient.Get(u.String())
+	if err != nil {
+		if errors.Is(err, errForbiddenAddress) {
+			return nil, errForbiddenAddress
+		}
+		return nil, fmt.Errorf("failed to fetch the catalog")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("the catalog answered with status %d", resp.StatusCode)
+	}
+
+	var books []PostBookDTO
+	if err = json.NewDecoder(io.LimitReader(resp.Body, maxImportBytes)).Decode(&books); err != nil {
+		return nil, fmt.Errorf("the catalog is not a JSON array of books")
+	}
+	if len(books) > maxImportBooks {
+		return nil, fmt.Errorf("the catalog holds more than %d books", maxImportBooks)
+	}
+	return books, nil
+}
+
+type SkippedBook struct {
+	Index  int          `json:"index"`
+	Errors []FieldError `json:"errors"`
+}
+
+type ImportSummary struct {
+	Inserted int           `json:"inserted"`
+	Skipped  []SkippedBook `json:"skipped"`
+	// Positions of the books that got the placeholder author, with
+	// ?defaults=true
+	Incomplete []int `json:"incomplete,omitempty"`
+}
+
+// Author given to imported books without one when the client asks for it
+// with ?defaults=true, set through IMPORT_DEFAULT_AUTHOR
+const defaultImportAuthor = "Unknown"
+
+// Reads ?defaults=true and, when set, gives every book without an author
+// the placeholder instead of having it rejected. A missing year is already
+// stored as 0. Returns the positions of the books that were filled in.
+func importDefaultsFromQuery(c echo.Context, books []PostBookDTO, author string) ([]int, error) {
+	raw := c.QueryParam("defaults")
+	if raw == "" {
+		return nil, nil
+	}
+	enabled, err := strconv.ParseBool(raw)
+	if err != nil {
+		return nil, fmt.Errorf("defaults must be true or false")
+	}
+	if !enabled {
+		return nil, nil
+	}
+	var filled []int
+	for i := range books {
+		if normalizeText(books[i].Author) == "" {
+			books[i].Author = author
+			filled = append(filled, i)
+		}
+	}
+	return filled, nil
+}
+
+// Validates the books and inserts the valid ones in a single round trip.
+// Books that are invalid, or rejected by the database (e.g. a duplicate
+// key), are reported by their position in the input.
+func importBooks(coll *mongo.Collection, books []PostBookDTO, limits BookLimits) (ImportSummary, error) {
+	summary := ImportSummary{Skipped: make([]SkippedBook, 0)}
+	now := time.Now().UTC()
+
+	docs := make([]interface{}, 0, len(books))
+	positions := make([]int, 0, len(books))
+	for i, book := range books {
+		bookStore := book.ToStore()
+		if errs := validateBook(bookStore, limits); errs != nil {
+			summary.Skipped = append(summary.Skipped, SkippedBook{Index: i, Errors: errs})
+			continue
+		}
+		bookStore.CreatedAt = now
+		bookStore.UpdatedAt = now
+		docs = append(docs, bookStore)
+		positions = append(positions, i)
+	}
+	if len(docs) == 0 {
+		return summary, nil
+	}
+
+	// Unordered, so one rejected book does not stop the rest
+	_, err := coll.InsertMany(context.TODO(), docs, options.InsertMany().SetOrdered(false))
+	var writeErr mongo.BulkWriteException
+	if errors.As(err, &writeErr) && writeErr.WriteConcernError == nil {
+		for _, we := range writeErr.WriteErrors {
+			summary.Skipped = append(summary.Skipped, SkippedBook{
+				Index:  positions[we.Index],
+				Errors: []FieldError{{Field: "", Message: we.Message}},
+			})
+		}
+		summary.Inserted = len(docs) - len(writeErr.WriteErrors)
+		return summary, nil
+	}
+	if err != nil {
+		return summary, err
+	}
+	summary.Inserted = len(docs)
+	return summary, nil
+}
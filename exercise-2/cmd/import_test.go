@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// Serves the catalog under a public looking hostname: importClient refuses
+// to connect to the loopback address of the test server, so for the test it
+// is swapped for one that dials the server whatever the host.
+func serveCatalog(t *testing.T, catalog string) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(catalog))
+	}))
+	t.Cleanup(srv.Close)
+
+	client := importClient
+	t.Cleanup(func() { importClient = client })
+	importClient = &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network string, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, srv.Listener.Addr().String())
+			},
+		},
+		CheckRedirect: client.CheckRedirect,
+	}
+	return "http://catalog.example.org/books.json"
+}
+
+func TestImportFromURL(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		catalogURL := serveCatalog(t, `[
+			{"name": "Frankenstein", "author": "Mary Shelley", "pages": 280, "year": 1818},
+			{"name": "The Black Cat", "author": "Edgar Allan Poe", "pages": 280, "year": 1843},
+			{"name": "", "author": "Nobody"}
+		]`)
+
+		mt.AddMockResponses(writeReply(2))
+		rec := serve(e, http.MethodPost, "/api/books/import-url", `{"url": "`+catalogURL+`"}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+		var summary ImportSummary
+		if err := json.Unmarshal(rec.Body.Bytes(), &summary); err != nil {
+			t.Fatal(err)
+		}
+		if summary.Inserted != 2 || len(summary.Skipped) != 1 || summary.Skipped[0].Index != 2 {
+			t.Errorf("got %+v, want 2 inserted and the nameless book skipped", summary)
+		}
+		docs, err := sentCommands(mt, "insert")[0].Command.Lookup("documents").Array().Values()
+		if err != nil || len(docs) != 2 {
+			t.Errorf("inserted %d books in one round trip (%v), want 2", len(docs), err)
+		}
+	})
+}
+
+func TestImportFromLoopbackRejected(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.Error("the catalog on the loopback address was fetched")
+		}))
+		defer srv.Close()
+
+		rec := serve(e, http.MethodPost, "/api/books/import-url", `{"url": "`+srv.URL+`"}`)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want 400: %s", rec.Code, rec.Body.String())
+		}
+		if got := decodeEnvelope(t, rec.Body.Bytes()); got.Message != errForbiddenAddress.Error() {
+			t.Errorf("message = %q, want %q", got.Message, errForbiddenAddress.Error())
+		}
+	})
+}
+
+// With ?defaults=true a book without an author is stored under the
+// placeholder and reported as incomplete instead of being skipped
+func TestImportDefaultsFillInTheAuthor(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		books := `[
+			{"name": "Frankenstein", "author": "Mary Shelley", "pages": 280, "year": 1818},
+			{"name": "Beowulf", "pages": 96}
+		]`
+
+		mt.AddMockResponses(writeReply(2))
+		rec := serve(e, http.MethodPost, "/api/books/bulk?defaults=true", books)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+		var summary ImportSummary
+		if err := json.Unmarshal(rec.Body.Bytes(), &summary); err != nil {
+			t.Fatal(err)
+		}
+		if summary.Inserted != 2 || len(summary.Skipped) != 0 || !slices.Equal(summary.Incomplete, []int{1}) {
+			t.Errorf("got %+v, want both inserted and book 1 incomplete", summary)
+		}
+		doc := sentCommands(mt, "insert")[0].Command.Lookup("documents").Array().Index(1).Value().Document()
+		if author := doc.Lookup("bookauthor").StringValue(); author != defaultImportAuthor {
+			t.Errorf("author = %q, want %q", author, defaultImportAuthor)
+		}
+		if lower := doc.Lookup("bookauthor_lower").StringValue(); lower != authorKey(defaultImportAuthor) {
+			t.Errorf("bookauthor_lower = %q, want %q", lower, authorKey(defaultImportAuthor))
+		}
+
+		// Without the flag the same book is rejected
+		mt.ClearEvents()
+		mt.AddMockResponses(writeReply(1))
+		rec = serve(e, http.MethodPost, "/api/books/bulk", books)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+		summary = ImportSummary{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &summary); err != nil {
+			t.Fatal(err)
+		}
+		if summary.Inserted != 1 || len(summary.Skipped) != 1 || summary.Skipped[0].Index != 1 || summary.Incomplete != nil {
+			t.Errorf("got %+v, want book 1 skipped", summary)
+		}
+		if docs, _ := sentCommands(mt, "insert")[0].Command.Lookup("documents").Array().Values(); len(docs) != 1 {
+			t.Errorf("inserted %d books, want 1", len(docs))
+		}
+	})
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The indexes of the books collection. They are created on startup and can
+// be rebuilt through POST /api/admin/reindex.
+// More on indexes: https://www.mongodb.com/docs/manual/indexes/
+var bookIndexes = []mongo.IndexModel{
+	{
+		// Full text search over names and authors
+		Keys:    bson.D{{Key: "bookname", Value: "text"}, {Key: "bookauthor", Value: "text"}},
+		Options: options.Index().SetName("books_text"),
+	},
+	{
+		// An ISBN belongs to one book only, however it is hyphenated. Books
+		// without one are left out of the index, so any number of them can
+		// be stored.
+		Keys: bson.D{{Key: "bookisbn_digits", Value: 1}},
+		Options: options.Index().
+			SetName("books_isbn_digits_unique").
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"bookisbn_digits": bson.M{"$type": "string", "$gt": ""}}),
+	},
+	{
+		Keys:    bson.D{{Key: "bookauthor", Value: 1}},
+		Options: options.Index().SetName("books_author"),
+	},
+	{
+		// Lookups by author ignoring case, see authorKey
+		Keys:    bson.D{{Key: "bookauthor_lower", Value: 1}},
+		Options: options.Index().SetName("books_author_lower"),
+	},
+}
+
+// Creates the indexes that do not exist yet. Indexes that already exist
+// with the same definition are left alone.
+func prepareBookIndexes(coll *mongo.Collection) error {
+	_, err := coll.Indexes().CreateMany(context.TODO(), bookIndexes)
+	return err
+}
+
+// Brings the indexes of the books collection in line with bookIndexes and
+// returns the names of the indexes afterwards. Missing indexes are created
+// before anything is dropped, so the unique ISBN index is never gone in
+// between. Indexes no longer in bookIndexes are dropped at the end.
+func reindexBooks(coll *mongo.Collection) ([]string, error) {
+	if err := prepareBookIndexes(coll); err != nil {
+		return nil, err
+	}
+
+	specs, err := coll.Indexes().ListSpecifications(context.TODO())
+	if err != nil {
+		return nil, err
+	}
+	wanted := map[string]bool{"_id_": true}
+	for _, index := range bookIndexes {
+		wanted[*index.Options.Name] = true
+	}
+	names := make([]string, 0, len(specs))
+	for _, spec := range specs {
+		if wanted[spec.Name] {
+			names = append(names, spec.Name)
+			continue
+		}
+		if _, err := coll.Indexes().DropOne(context.TODO(), spec.Name); err != nil {
+			return nil, err
+		}
+	}
+	return names, nil
+}
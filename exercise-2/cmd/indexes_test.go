@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestReindex(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		repo := testRepo(mt)
+		repo.AdminToken = "secret"
+		e := newTestServer(repo)
+		// A successful reindex marks the indexes as ready
+		defer indexesReady.Store(indexesReady.Load())
+
+		want := []string{"_id_"}
+		specs := []interface{}{bson.M{"v": 2, "key": bson.M{"_id": 1}, "name": "_id_"}}
+		for _, index := range bookIndexes {
+			want = append(want, *index.Options.Name)
+			specs = append(specs, bson.M{"v": 2, "key": index.Keys, "name": *index.Options.Name})
+		}
+
+		// An index we no longer use
+		stale := bson.M{"v": 2, "key": bson.M{"bookname": 1}, "name": "books_name"}
+
+		// createIndexes, the listing and dropping the stale index
+		mt.AddMockResponses(mtest.CreateSuccessResponse(), cursorReply(t, append(specs, stale)...), mtest.CreateSuccessResponse())
+		rec := serve(e, http.MethodPost, "/api/admin/reindex", "", "Authorization", "Bearer secret")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+		var got struct {
+			Indexes []string `json:"indexes"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got.Indexes, want) {
+			t.Errorf("indexes %v, want %v", got.Indexes, want)
+		}
+
+		var created []string
+		values, err := sentCommands(mt, "createIndexes")[0].Command.Lookup("indexes").Array().Values()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, index := range values {
+			created = append(created, index.Document().Lookup("name").StringValue())
+		}
+		if !slices.Equal(created, want[1:]) {
+			t.Errorf("created %v, want %v", created, want[1:])
+		}
+
+		// Nothing we use is dropped, only the stale index after the others
+		// were created
+		drops := sentCommands(mt, "dropIndexes")
+		if len(drops) != 1 || drops[0].Command.Lookup("index").StringValue() != "books_name" {
+			t.Errorf("sent %v, want only books_name dropped", drops)
+		}
+		if !indexesReady.Load() {
+			t.Error("not ready after a successful reindex")
+		}
+	})
+}
+
+// Data that keeps an index from being built, e.g. two books sharing an
+// ISBN, fails the reindex without dropping anything, and we are not ready
+func TestReindexFails(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		repo := testRepo(mt)
+		repo.AdminToken = "secret"
+		e := newTestServer(repo)
+		defer indexesReady.Store(indexesReady.Load())
+		indexesReady.Store(true)
+
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 11000, Message: "E11000 duplicate key error"}))
+		rec := serve(e, http.MethodPost, "/api/admin/reindex", "", "Authorization", "Bearer secret")
+		if rec.Code != http.StatusInternalServerError {
+			t.Fatalf("status = %d, want 500: %s", rec.Code, rec.Body.String())
+		}
+		if drops := sentCommands(mt, "dropIndexes"); len(drops) != 0 {
+			t.Errorf("dropped indexes although the reindex failed")
+		}
+		if indexesReady.Load() {
+			t.Error("still ready after a failed reindex")
+		}
+	})
+}
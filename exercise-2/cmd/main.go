@@ -2,13 +2,18 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"regexp"
 	"slices"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/labstack/echo/v4"
@@ -21,22 +26,40 @@ import (
 )
 
 // Defines a "model" that we can use to communicate with the
-// frontend or the database
+// frontend or the database. The bson tags pin the field names used in the
+// database (they are the lowercased names the driver picked before), so
+// renaming a Go field does not break the books already stored. Filters and
+// updates must use these names.
 type BookStore struct {
 	ID         primitive.ObjectID `bson:"_id,omitempty"`
-	BookName   string
-	BookAuthor string
-	BookISBN   string
-	BookPages  int
-	BookYear   int
+	BookName   string             `bson:"bookname"`
+	BookAuthor string             `bson:"bookauthor"`
+	// Lowercase copy of BookAuthor, so author lookups ignoring case can use
+	// an index instead of a regex (see authorKey)
+	BookAuthorLower string `bson:"bookauthor_lower"`
+	BookISBN        string `bson:"bookisbn"`
+	// The ISBN without hyphens, for lookups and uniqueness (see isbnKey)
+	BookISBNDigits string    `bson:"bookisbn_digits,omitempty"`
+	BookPages      int       `bson:"bookpages"`
+	BookYear       int       `bson:"bookyear"`
+	Tags           []string  `bson:"tags,omitempty"`
+	CoverURL       string    `bson:"coverurl,omitempty"`
+	CreatedAt      time.Time `bson:"createdat,omitempty"`
+	UpdatedAt      time.Time `bson:"updatedat,omitempty"`
 }
 
 // Wraps the "Template" struct to associate a necessary method
 // to determine the rendering procedure
 type Template struct {
 	tmpl *template.Template
+	// With DEV_MODE the views are parsed again for every page, so changes
+	// show up without a restart
+	reload bool
 }
 
+// Where the templates are loaded from
+const templateGlob = "views/*.html"
+
 // Preload the available templates for the view folder.
 // This builds a local "database" of all available "blocks"
 // to render upon request, i.e., replace the respective
@@ -45,9 +68,10 @@ type Template struct {
 // to get to know more about templating
 // You can also read Golang's documentation on their templating
 // https://pkg.go.dev/text/template
-func loadTemplates() *Template {
+func loadTemplates(reload bool) *Template {
 	return &Template{
-		tmpl: template.Must(template.ParseGlob("views/*.html")),
+		tmpl:   template.Must(template.ParseGlob(templateGlob)),
+		reload: reload,
 	}
 }
 
@@ -58,7 +82,21 @@ func loadTemplates() *Template {
 // The difference lies that interfaces declare methods whether struct only
 // implement them, i.e., only define them. Such differentiation is important
 // for a compiler to ensure types provide implementations of such methods.
+// Since we use "html/template" (and not "text/template"), every value is
+// escaped according to where it lands in the page, so a book named
+// "<script>" is shown as text. Never wrap book data in template.HTML, as
+// that would switch the escaping off.
+// While reloading, a view broken by an edit answers with a 500 (and the
+// reason in the log) before anything of the page is written.
 func (t *Template) Render(w io.Writer, name string, data interface{}, ctx echo.Context) error {
+	if t.reload {
+		tmpl, err := template.ParseGlob(templateGlob)
+		if err != nil {
+			log.Printf("failed to parse the templates: %v\n", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to parse the templates")
+		}
+		return tmpl.ExecuteTemplate(w, name, data)
+	}
 	return t.tmpl.ExecuteTemplate(w, name, data)
 }
 
@@ -70,7 +108,7 @@ func (t *Template) Render(w io.Writer, name string, data interface{}, ctx echo.C
 // files, that you pass the proper value to ensure communication with the
 // database
 // More on what bson means: https://www.mongodb.com/docs/drivers/go/current/fundamentals/bson/
-func prepareDatabase(client *mongo.Client, dbName string, collecName string) (*mongo.Collection, error) {
+func prepareDatabase(client *mongo.Client, dbName string, collecName string, opts CollectionOptions) (*mongo.Collection, error) {
 	db := client.Database(dbName)
 
 	names, err := db.ListCollectionNames(context.TODO(), bson.D{{}})
@@ -78,12 +116,23 @@ func prepareDatabase(client *mongo.Client, dbName string, collecName string) (*m
 		return nil, err
 	}
 	if !slices.Contains(names, collecName) {
-		cmd := bson.D{{"create", collecName}}
+		cmd := createCollectionCommand(collecName, opts)
 		var result bson.M
 		if err = db.RunCommand(context.TODO(), cmd).Decode(&result); err != nil {
 			log.Fatal(err)
 			return nil, err
 		}
+	} else if opts.Validator {
+		// Existing books breaking the rules can still be updated
+		// ("moderate"), but new ones have to follow them
+		cmd := bson.D{
+			{Key: "collMod", Value: collecName},
+			{Key: "validator", Value: bookCollectionValidator},
+			{Key: "validationLevel", Value: "moderate"},
+		}
+		if err = db.RunCommand(context.TODO(), cmd).Err(); err != nil {
+			return nil, err
+		}
 	}
 
 	coll := db.Collection(collecName)
@@ -91,7 +140,7 @@ func prepareDatabase(client *mongo.Client, dbName string, collecName string) (*m
 }
 
 // Here we prepare some fictional data and we insert it into the database
-// the first time we connect to it. Otherwise, we check if it already exists.
+// the first time we connect to it, i.e. while the collection is empty.
 func prepareData(client *mongo.Client, coll *mongo.Collection) {
 	startData := []BookStore{
 		{
@@ -117,6 +166,18 @@ func prepareData(client *mongo.Client, coll *mongo.Collection) {
 		},
 	}
 
+	// The sample books only ever go into an empty collection, so they never
+	// end up between the books of a real catalog, whatever their ISBNs are
+	count, err := coll.CountDocuments(context.TODO(), bson.D{})
+	if err != nil {
+		log.Printf("warning: failed to count the books, skipping the sample books: %v\n", err)
+		return
+	}
+	if count > 0 {
+		log.Printf("the collection already holds %d books, skipping the sample books\n", count)
+		return
+	}
+
 	// This syntax helps us iterate over arrays. It behaves similar to Python
 	// However, range always returns a tuple: (idx, elem). You can ignore the idx
 	// by using _.
@@ -125,39 +186,62 @@ func prepareData(client *mongo.Client, coll *mongo.Collection) {
 	// might return a ret value that includes res and the err, others might have
 	// an out parameter.
 	for _, book := range startData {
-		cursor, err := coll.Find(context.TODO(), book)
-		var results []BookStore
-		if err = cursor.All(context.TODO(), &results); err != nil {
-			panic(err)
-		}
-		if len(results) > 1 {
-			log.Fatal("more records were found")
-		} else if len(results) == 0 {
-			result, err := coll.InsertOne(context.TODO(), book)
-			if err != nil {
-				panic(err)
-			} else {
-				fmt.Printf("%+v\n", result)
-			}
-
+		book.BookAuthorLower = authorKey(book.BookAuthor)
+		book.BookISBNDigits = isbnKey(book.BookISBN)
+		book.CreatedAt = time.Now().UTC()
+		book.UpdatedAt = book.CreatedAt
+		result, err := coll.InsertOne(context.TODO(), book)
+		if err != nil {
+			log.Printf("warning: failed to insert seed book %s: %v\n", maskISBN(book.BookISBN), err)
 		} else {
-			for _, res := range results {
-				cursor.Decode(&res)
-				fmt.Printf("%+v\n", res)
-			}
+			fmt.Printf("%+v\n", result)
 		}
 	}
 }
 
+// The sample books are handy locally, but a real catalog should not get
+// them. SEED_DATA defaults to true, the Docker image turns it off.
+func seedFromEnv(client *mongo.Client, coll *mongo.Collection) error {
+	seed, err := boolFromEnv("SEED_DATA", true)
+	if err != nil {
+		return err
+	}
+	if !seed {
+		log.Println("SEED_DATA is off, skipping the sample books")
+		return nil
+	}
+	log.Println("SEED_DATA is on, inserting the sample books")
+	prepareData(client, coll)
+	return nil
+}
+
 // Generic method to perform "SELECT * FROM BOOKS" (if this was SQL, which
 // it is not :D ), and then we convert it into an array of map. In Golang, you
 // define a map by writing map[<key type>]<value type>{<key>:<value>}.
 // interface{} is a special type in Golang, basically a wildcard...
-func findAllBooks(coll *mongo.Collection) []map[string]interface{} {
-	cursor, err := coll.Find(context.TODO(), bson.D{{}})
+// The books are decoded one by one, so a document that does not fit
+// BookStore (e.g. pages stored as a string by a bad import) can be named in
+// the log. It fails the whole listing with an error instead of a panic.
+// The options may sort the books, see sortOptionsFromQuery.
+func findAllBooks(coll *mongo.Collection, opts ...*options.FindOptions) ([]map[string]interface{}, error) {
+	defer timeQuery(coll, "find", bson.D{{}})()
+	cursor, err := coll.Find(context.TODO(), bson.D{{}}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.TODO())
+
 	var results []BookStore
-	if err = cursor.All(context.TODO(), &results); err != nil {
-		panic(err)
+	for cursor.Next(context.TODO()) {
+		var book BookStore
+		if err = cursor.Decode(&book); err != nil {
+			log.Printf("failed to decode book %v: %v", cursor.Current.Lookup("_id"), err)
+			return nil, err
+		}
+		results = append(results, book)
+	}
+	if err = cursor.Err(); err != nil {
+		return nil, err
 	}
 
 	var ret []map[string]interface{}
@@ -172,24 +256,134 @@ func findAllBooks(coll *mongo.Collection) []map[string]interface{} {
 		})
 	}
 
-	return ret
+	return ret, nil
+}
+
+// Runs a query and converts the results into DTOs. The returned slice is never
+// nil, so handlers answer with [] instead of null when nothing matches.
+func findBookDTOs(coll *mongo.Collection, filter interface{}, opts ...*options.FindOptions) ([]BookDTO, error) {
+	defer timeQuery(coll, "find", filter)()
+	cursor, err := coll.Find(context.TODO(), filter, opts...)
+	if err != nil {
+		return nil, err
+	}
+	var results []BookStore
+	if err = cursor.All(context.TODO(), &results); err != nil {
+		return nil, err
+	}
+
+	books := make([]BookDTO, 0, len(results))
+	for _, res := range results {
+		books = append(books, res.ToDTO())
+	}
+	return books, nil
 }
 
 type BookDTO struct {
-	Id     string `json:"id"`
-	Name   string `json:"name"`
-	Author string `json:"author"`
-	Pages  int    `json:"pages"`
-	Year   int    `json:"year"`
-	Isbn   string `json:"isbn,omitempty"`
+	Id        string     `json:"id" xml:"id" validate:"required,mongodb"`
+	Name      string     `json:"name" xml:"name"`
+	Author    string     `json:"author" xml:"author"`
+	Pages     int        `json:"pages" xml:"pages"`
+	Year      int        `json:"year" xml:"year"`
+	Isbn      string     `json:"isbn,omitempty" xml:"isbn,omitempty"`
+	Tags      []string   `json:"tags,omitempty" xml:"tags>tag,omitempty"`
+	CoverURL  string     `json:"coverUrl,omitempty" xml:"coverUrl,omitempty"`
+	CreatedAt *time.Time `json:"createdAt,omitempty" xml:"createdAt,omitempty"`
+	UpdatedAt *time.Time `json:"updatedAt,omitempty" xml:"updatedAt,omitempty"`
 }
 
 type PostBookDTO struct {
-	Name   string `json:"name"`
-	Author string `json:"author"`
-	Pages  int    `json:"pages"`
-	Year   int    `json:"year"`
-	Isbn   string `json:"isbn,omitempty"`
+	Name     string   `json:"name" validate:"required"`
+	Author   string   `json:"author" validate:"required"`
+	Pages    int      `json:"pages" validate:"gte=0"`
+	Year     int      `json:"year" validate:"gte=0"`
+	Isbn     string   `json:"isbn,omitempty"`
+	Tags     []string `json:"tags,omitempty" validate:"dive,required"`
+	CoverURL string   `json:"coverUrl,omitempty" validate:"omitempty,http_url"`
+}
+
+// Payload of PUT /api/authors, renaming every book of one author
+type AuthorRenameDTO struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Converts the payload received from the frontend into the model we store.
+// Text fields are normalized here, so stray whitespace never reaches the
+// database and breaks the grouping by author.
+func (b PostBookDTO) ToStore() BookStore {
+	return BookStore{
+		BookName:        normalizeText(b.Name),
+		BookAuthor:      normalizeText(b.Author),
+		BookAuthorLower: authorKey(normalizeText(b.Author)),
+		BookISBN:        normalizeText(b.Isbn),
+		BookISBNDigits:  isbnKey(normalizeText(b.Isbn)),
+		BookPages:       b.Pages,
+		BookYear:        b.Year,
+		Tags:            normalizeTags(b.Tags),
+		CoverURL:        strings.TrimSpace(b.CoverURL),
+	}
+}
+
+// The value stored in bookauthor_lower. Lookups by author compare against
+// it, so "mary shelley" finds the books of "Mary Shelley".
+func authorKey(author string) string {
+	return strings.ToLower(author)
+}
+
+// Trims leading and trailing whitespace and collapses inner runs of
+// whitespace into a single space, e.g. "  Mary  Shelley " -> "Mary Shelley"
+func normalizeText(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// Lowercases the tags and drops empty and repeated ones, keeping the order
+// they were given in. Never returns nil, so an update can clear the tags.
+func normalizeTags(tags []string) []string {
+	ret := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(normalizeText(tag))
+		if tag != "" && !slices.Contains(ret, tag) {
+			ret = append(ret, tag)
+		}
+	}
+	return ret
+}
+
+// Builds a case-insensitive filter matching the whole value. Regex
+// metacharacters are escaped, so the value is always matched literally.
+func equalFoldFilter(value string) primitive.Regex {
+	return primitive.Regex{Pattern: "^" + regexp.QuoteMeta(value) + "$", Options: "i"}
+}
+
+// Converts a stored book into the representation we send to the frontend
+func (b BookStore) ToDTO() BookDTO {
+	dto := BookDTO{
+		Id:       b.ID.Hex(),
+		Name:     b.BookName,
+		Author:   b.BookAuthor,
+		Pages:    b.BookPages,
+		Year:     b.BookYear,
+		Isbn:     b.BookISBN,
+		Tags:     b.Tags,
+		CoverURL: b.CoverURL,
+	}
+	// Books stored before we tracked timestamps simply leave them out
+	if !b.CreatedAt.IsZero() {
+		dto.CreatedAt = &b.CreatedAt
+	}
+	if !b.UpdatedAt.IsZero() {
+		dto.UpdatedAt = &b.UpdatedAt
+	}
+	return dto
+}
+
+// Serves HTTPS when a certificate and key are given, plain HTTP otherwise
+func startServer(e *echo.Echo, address string, certFile string, keyFile string) error {
+	if certFile != "" {
+		return e.StartTLS(address, certFile, keyFile)
+	}
+	return e.Start(address)
 }
 
 func main() {
@@ -200,220 +394,384 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	uri := os.Getenv("DATABASE_URI")
-	if len(uri) == 0 {
-		fmt.Printf("failure to load env variable\n")
+	// The URI comes from DATABASE_URI, or from the file DATABASE_URI_FILE
+	// points to (Docker secrets)
+	uri, err := databaseURIFromEnv()
+	if err != nil {
+		fmt.Printf("%v\n", err)
 		os.Exit(1)
 	}
 
-	// TODO: make sure to pass the proper username, password, and port
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	// The app name tells the services apart in the server logs. With
+	// MONGO_SLOW_COMMAND (e.g. 100ms) commands taking longer than that are
+	// logged here as well.
+	appName := appNameFromEnv()
+	clientOptions := options.Client().ApplyURI(uri).SetAppName(appName)
+	slowCommand, err := durationFromEnv("MONGO_SLOW_COMMAND", 0)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+	if slowCommand > 0 {
+		clientOptions.SetMonitor(slowCommandMonitor(appName, slowCommand))
+	}
+
+	// The URI may hold a password, so it is only ever logged redacted
+	client, err := mongo.Connect(ctx, clientOptions)
 	if err != nil {
-		fmt.Printf("failed to create client for MongoDB\n")
+		fmt.Printf("failed to create client for MongoDB at %s: %s\n", redactURI(uri), redactURIError(err, uri))
 		os.Exit(1)
 	}
 
-	err = client.Ping(ctx, readpref.Primary())
+	// Retry the ping a few times (MONGO_CONNECT_ATTEMPTS, every
+	// MONGO_CONNECT_INTERVAL) before giving up
+	attempts, err := intFromEnv("MONGO_CONNECT_ATTEMPTS", 5)
+	if err != nil || attempts < 1 {
+		fmt.Printf("MONGO_CONNECT_ATTEMPTS must be a positive number\n")
+		os.Exit(1)
+	}
+	interval, err := durationFromEnv("MONGO_CONNECT_INTERVAL", 2*time.Second)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+	err = waitForDatabase(func(ctx context.Context) error {
+		if err := client.Ping(ctx, readpref.Primary()); err != nil {
+			return errors.New(redactURIError(err, uri))
+		}
+		return nil
+	}, attempts, interval)
 	if err != nil {
-		fmt.Printf("failed to connect to MongoDB, please make sure the database is running\n")
+		fmt.Printf("failed to connect to MongoDB at %s, please make sure the database is running: %s\n", redactURI(uri), redactURIError(err, uri))
 		os.Exit(1)
 	}
 
 	// This is another way to specify the call of a function. You can define inline
 	// functions (or anonymous functions, similar to the behavior in Python)
+	// The startup context has long expired by the time we shut down, so the
+	// disconnect gets a fresh one
 	defer func() {
-		if err = client.Disconnect(ctx); err != nil {
+		disconnectCtx, cancelDisconnect := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancelDisconnect()
+		if err = client.Disconnect(disconnectCtx); err != nil {
 			panic(err)
 		}
 	}()
 
 	// You can use such name for the database and collection, or come up with
 	// one by yourself!
-	coll, err := prepareDatabase(client, "exercise-1", "information")
+	collectionOptions, err := collectionOptionsFromEnv()
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+	coll, err := prepareDatabase(client, "exercise-1", "information", collectionOptions)
+	if err != nil {
+		fmt.Printf("failed to prepare the database: %v\n", err)
+		os.Exit(1)
+	}
 
-	prepareData(client, coll)
+	// Writes go through a handle with the configured write concern
+	writeConcern, err := writeConcernFromEnv()
+	if err != nil {
+		fmt.Printf("invalid write concern: %v\n", err)
+		os.Exit(1)
+	}
+	if writeConcern != nil {
+		coll = coll.Database().Collection(coll.Name(), options.Collection().SetWriteConcern(writeConcern))
+	}
 
-	// Here we prepare the server
-	e := echo.New()
+	// Processed Idempotency-Keys of POST /api/books, kept for
+	// IDEMPOTENCY_TTL (default 24h)
+	idempotencyTTL, err := durationFromEnv("IDEMPOTENCY_TTL", 24*time.Hour)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+	idempotencyKeys, err := prepareIdempotencyKeys(coll.Database(), idempotencyTTL)
+	if err != nil {
+		fmt.Printf("failed to prepare the idempotency keys: %v\n", err)
+		os.Exit(1)
+	}
 
-	// Define our custom renderer
-	e.Renderer = loadTemplates()
+	// Brings books stored by older versions up to date, see migrations
+	if err = runMigrations(coll.Database(), coll, migrations); err != nil {
+		fmt.Printf("failed to migrate the books: %v\n", err)
+		os.Exit(1)
+	}
 
-	// Log the requests. Please have a look at echo's documentation on more
-	// middleware
-	e.Use(middleware.Logger())
+	// The indexes come after the migrations, which fill fields they cover.
+	// Existing data may keep an index from being built, e.g. two books
+	// sharing an ISBN. The service still starts, and once the data is fixed
+	// POST /api/admin/reindex builds the missing indexes.
+	if err = prepareBookIndexes(coll); err != nil {
+		log.Printf("failed to prepare the book indexes: %v", err)
+	} else {
+		indexesReady.Store(true)
+	}
 
-	e.Static("/css", "css")
+	// Our own queries taking longer than SLOW_QUERY_THRESHOLD are logged
+	slowQueryThreshold, err = durationFromEnv("SLOW_QUERY_THRESHOLD", slowQueryThreshold)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
 
-	// Endpoint definition. Here, we divided into two groups: top-level routes
-	// starting with /, which usually serve webpages. For our RESTful endpoints,
-	// we prefix the route with /api to indicate more information or resources
-	// are available under such route.
-	e.GET("/", func(c echo.Context) error {
-		return c.Render(200, "index", nil)
-	})
+	// LOG_MASK_ISBN hides most of the ISBN in the log lines about books
+	maskISBNInLogs, err = boolFromEnv("LOG_MASK_ISBN", false)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
 
-	e.GET("/books", func(c echo.Context) error {
-		books := findAllBooks(coll)
-		return c.Render(200, "book-table", books)
-	})
+	if err = seedFromEnv(client, coll); err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
 
-	e.GET("/authors", func(c echo.Context) error {
-		books := findAllBooks(coll)
-		author := make([]interface{}, 0)
-		for _, book := range books {
-			author = append(author, book["BookAuthor"])
-		}
-		return c.Render(200, "authors-table", author)
-	})
+	// Previous versions of updated and deleted books, written with the same
+	// write concern as the books (nil keeps the default)
+	history := coll.Database().Collection("book_history", options.Collection().SetWriteConcern(writeConcern))
 
-	e.GET("/years", func(c echo.Context) error {
-		books := findAllBooks(coll)
-		years := make([]interface{}, 0)
-		for _, book := range books {
-			years = append(years, book["BookYear"])
-		}
-		return c.Render(200, "year-table", years)
-	})
+	// GET handlers read through their own handle of the collection, so they
+	// can use the configured read preference while writes stay on primary
+	readPref, err := readPreferenceFromEnv()
+	if err != nil {
+		fmt.Printf("invalid READ_PREFERENCE: %v\n", err)
+		os.Exit(1)
+	}
+	readColl := coll.Database().Collection(coll.Name(), options.Collection().SetReadPreference(readPref))
 
-	e.GET("/search", func(c echo.Context) error {
-		return c.Render(200, "search-bar", nil)
-	})
+	// Cancelled once the process is asked to stop, which stops the
+	// background workers and the server
+	appCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	e.GET("/create", func(c echo.Context) error {
-		return c.NoContent(http.StatusNoContent)
-	})
+	// Optionally recompute the author statistics in the background every
+	// AUTHOR_STATS_REFRESH (e.g. 30s) instead of on each request
+	authorStats := newAuthorStatsCache(readColl)
+	statsInterval, err := durationFromEnv("AUTHOR_STATS_REFRESH", 0)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+	if statsInterval > 0 {
+		go authorStats.Run(appCtx, statsInterval)
+	}
 
-	e.GET("/api/books", func(c echo.Context) error {
-		books := findAllBooks(coll)
-		payload := make([]BookDTO, 0)
-		for _, book := range books {
-			obj := BookDTO{
-				Id:     book["ID"].(string),
-				Name:   book["BookName"].(string),
-				Author: book["BookAuthor"].(string),
-				Pages:  book["BookPages"].(int),
-				Year:   book["BookYear"].(int),
-				Isbn:   book["BookISBN"].(string),
-			}
-			payload = append(payload, obj)
+	// Sanity limits for new books (MAX_PAGES, MAX_YEAR)
+	limits, err := bookLimitsFromEnv()
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
 
-		}
-		return c.JSON(http.StatusOK, payload)
-	})
+	// Pagination of GET /api/books (DEFAULT_PAGE_SIZE, MAX_PAGE_SIZE)
+	pageSizes, err := pageSizesFromEnv()
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
 
-	e.POST("/api/books", func(c echo.Context) error {
-		book := new(PostBookDTO)
-		err = c.Bind(book)
-		if err != nil {
-			fmt.Println("error in conversion", err)
-			return c.JSON(http.StatusNotModified, "error in payload conversion ")
-		}
+	collation, err := collationFromEnv()
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
 
-		// create field to compare
-		objToComapare := bson.M{}
-		if book.Name != "" {
-			objToComapare["bookname"] = book.Name
-		}
-		if book.Author != "" {
-			objToComapare["bookauthor"] = book.Author
-		}
-		if book.Pages != 0 {
-			objToComapare["bookpages"] = book.Pages
-		}
-		if book.Year != 0 {
-			objToComapare["bookyear"] = book.Year
-		}
-		if book.Isbn != "" {
-			objToComapare["bookisbn"] = book.Isbn
-		}
+	maxResults, err := maxResultsFromEnv()
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
 
-		// check object existence
-		var existingBook BookStore
-		found := coll.FindOne(context.TODO(), objToComapare).Decode(&existingBook)
-		if found == nil {
-			return c.JSON(http.StatusNotModified, book)
-		}
+	// Author of imported books without one, with ?defaults=true
+	importDefaultAuthor := normalizeText(os.Getenv("IMPORT_DEFAULT_AUTHOR"))
+	if importDefaultAuthor == "" {
+		importDefaultAuthor = defaultImportAuthor
+	}
 
-		bookStore := BookStore{
-			BookName:   book.Name,
-			BookAuthor: book.Author,
-			BookPages:  book.Pages,
-			BookYear:   book.Year,
-			BookISBN:   book.Isbn,
-		}
-		result, err := coll.InsertOne(context.TODO(), bookStore)
-		if err != nil {
-			return c.JSON(http.StatusNotModified, "invalid on insertion")
-		}
-		bookId := result.InsertedID.(primitive.ObjectID)
-		insertedIDString := bookId.Hex()
-
-		payload := BookDTO{
-			Id:     insertedIDString,
-			Name:   book.Name,
-			Author: book.Author,
-			Pages:  book.Pages,
-			Year:   book.Year,
-			Isbn:   book.Isbn,
-		}
-		return c.JSON(http.StatusOK, payload)
-	})
+	// POST /api/admin/reset wipes the books, so it has to be switched on
+	allowReset, err := boolFromEnv("ALLOW_RESET", false)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
 
-	e.PUT("/api/books", func(c echo.Context) error {
-		book := new(BookDTO)
-		if err := c.Bind(book); err != nil {
-			return err
+	// STRICT_VALIDATION checks the bodies of POST /api/books and
+	// PUT /api/books/:id against schema/book.schema.json, rejecting unknown
+	// fields and wrong types instead of ignoring them
+	strict, err := boolFromEnv("STRICT_VALIDATION", false)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+	var bookSchema *JSONSchema
+	if strict {
+		if bookSchema, err = loadBookSchema(); err != nil {
+			fmt.Printf("%v\n", err)
+			os.Exit(1)
 		}
+	}
 
-		objId, err := primitive.ObjectIDFromHex(book.Id)
+	// Here we prepare the server
+	e := echo.New()
 
-		objToComapare := bson.M{}
-		if book.Name != "" {
-			objToComapare["bookname"] = book.Name
-		}
-		if book.Author != "" {
-			objToComapare["bookauthor"] = book.Author
-		}
-		if book.Pages != 0 {
-			objToComapare["bookpages"] = book.Pages
-		}
-		if book.Year != 0 {
-			objToComapare["bookyear"] = book.Year
-		}
-		if book.Isbn != "" {
-			objToComapare["bookisbn"] = book.Isbn
-		}
+	// Define our custom renderer. DEV_MODE=true picks up changes to the
+	// views without a restart; leave it off in production, since parsing
+	// them for every page is slow.
+	devMode, err := boolFromEnv("DEV_MODE", false)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+	e.Renderer = loadTemplates(devMode)
 
-		result, err := coll.UpdateOne(
-			context.TODO(),
-			bson.M{"_id": objId},
-			bson.M{
-				"$set": objToComapare,
-			})
-		fmt.Printf("update: mathced count - %s upsert count: %s update id: %s", result.MatchedCount, result.UpsertedCount)
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, "error in updating data")
-		}
-		return c.JSON(http.StatusOK, book)
+	// Checks the `validate` tags of the payloads, see BookValidator
+	e.Validator = newBookValidator()
+
+	// JSON_PRETTY=true indents all JSON responses, e.g. for debugging
+	prettyJSON, err := boolFromEnv("JSON_PRETTY", false)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+	if prettyJSON {
+		e.JSONSerializer = prettyJSONSerializer{}
+	}
+
+	// JSON errors for the API, the not-found page for the website
+	e.HTTPErrorHandler = httpErrorHandler(e.DefaultHTTPErrorHandler)
+
+	// Runs before routing, so /books/ can be sent to /books (TRAILING_SLASH)
+	trailingSlash, err := trailingSlashFromEnv()
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+	if trailingSlash != nil {
+		e.Pre(trailingSlash)
+	}
+
+	// Give every request an ID (X-Request-Id), which shows up in the logs
+	// and in error responses
+	e.Use(middleware.RequestID())
+
+	// Log the requests, as JSON or text depending on LOG_FORMAT. Please have
+	// a look at echo's documentation on more middleware
+	loggerConfig, err := loggerConfigFromEnv()
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+	e.Use(middleware.LoggerWithConfig(loggerConfig))
+
+	// Expose request rates, latencies and the amount of books for
+	// Prometheus. Registered before recoverPanics, so panics are counted.
+	metrics := newMetrics(coll)
+	e.Use(metrics.Middleware())
+	e.GET("/metrics", metrics.Handler)
+
+	// Answer panics with a 500 instead of crashing the request
+	e.Use(recoverPanics())
+
+	// Tell clients how long we took to answer
+	e.Use(responseTime())
+
+	// Compress responses for clients sending Accept-Encoding: gzip. Bodies
+	// shorter than GZIP_MIN_LENGTH bytes are sent as they are, since
+	// compressing them would cost more than it saves.
+	gzipMinLength, err := intFromEnv("GZIP_MIN_LENGTH", 1024)
+	if err != nil || gzipMinLength < 0 {
+		fmt.Printf("GZIP_MIN_LENGTH must be a non-negative number\n")
+		os.Exit(1)
+	}
+	// HEAD responses have no body to compress, and the gzip writer would
+	// drop the Content-Length they report
+	e.Use(middleware.GzipWithConfig(middleware.GzipConfig{
+		MinLength: gzipMinLength,
+		Skipper: func(c echo.Context) bool {
+			return c.Request().Method == http.MethodHead
+		},
+	}))
+
+	// Security headers against clickjacking and MIME sniffing
+	csp := os.Getenv("CONTENT_SECURITY_POLICY")
+	if csp == "" {
+		csp = defaultContentSecurityPolicy
+	}
+	e.Use(middleware.SecureWithConfig(middleware.SecureConfig{
+		XSSProtection:         "1; mode=block",
+		ContentTypeNosniff:    "nosniff",
+		XFrameOptions:         "SAMEORIGIN",
+		ContentSecurityPolicy: csp,
+	}))
+
+	// Answer with 503 when a handler takes longer than REQUEST_TIMEOUT
+	// (default 15s, 0 disables it). The handler keeps running in the
+	// background, only the request context gets cancelled.
+	requestTimeout, err := durationFromEnv("REQUEST_TIMEOUT", 15*time.Second)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+	if requestTimeout > 0 {
+		e.Use(middleware.TimeoutWithConfig(middleware.TimeoutConfig{
+			Timeout:      requestTimeout,
+			ErrorMessage: errorJSON(codeTimeout, "the request took too long"),
+		}))
+	}
+
+	// Static files may be cached by browsers for STATIC_MAX_AGE (default
+	// 1h). FAVICON_FILE replaces the default icon.
+	staticMaxAge, err := durationFromEnv("STATIC_MAX_AGE", time.Hour)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+	e.Group("/css", cacheControl(staticMaxAge)).Static("/", "css")
+	favicon := os.Getenv("FAVICON_FILE")
+	if favicon == "" {
+		favicon = "static/favicon.svg"
+	}
+	e.File("/favicon.ico", favicon, cacheControl(staticMaxAge))
+	e.File("/favicon.svg", favicon, cacheControl(staticMaxAge))
+
+	registerRoutes(e, BookRepository{
+		Books:               coll,
+		ReadBooks:           readColl,
+		History:             history,
+		IdempotencyKeys:     idempotencyKeys,
+		AuthorStats:         authorStats,
+		Limits:              limits,
+		PageSizes:           pageSizes,
+		BookSchema:          bookSchema,
+		AdminToken:          os.Getenv("ADMIN_TOKEN"),
+		Collation:           collation,
+		MaxResults:          maxResults,
+		AllowReset:          allowReset,
+		CollectionOptions:   collectionOptions,
+		ImportDefaultAuthor: importDefaultAuthor,
 	})
 
-	e.DELETE("/api/books/:id", func(c echo.Context) error {
-		id := c.Param("id")
-		objId, err := primitive.ObjectIDFromHex(id)
-		result, err := coll.DeleteOne(
-			context.TODO(),
-			bson.M{"_id": objId},
-		)
-		fmt.Println("deleted: ", result.DeletedCount)
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, "error in deleting the book")
-		}
-		if result.DeletedCount == 0 {
-			return c.JSON(http.StatusAccepted, "Book does not exist")
+	// HTTPS when TLS_CERT_FILE and TLS_KEY_FILE are given, plain HTTP
+	// otherwise
+	certFile, keyFile, err := tlsFilesFromEnv()
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+	go func() {
+		if err := startServer(e, ":3030", certFile, keyFile); err != nil && err != http.ErrServerClosed {
+			e.Logger.Fatal(err)
 		}
-		return c.JSON(http.StatusOK, "Book deleted successfully")
-	})
+	}()
 
-	e.Logger.Fatal(e.Start(":3030"))
+	// Wait for SIGINT/SIGTERM and give in-flight requests some time to finish
+	<-appCtx.Done()
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelShutdown()
+	if err := e.Shutdown(shutdownCtx); err != nil {
+		e.Logger.Fatal(err)
+	}
 }
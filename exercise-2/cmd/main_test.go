@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestPrepareDataTwice(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		// The first run finds an empty collection and inserts the three books
+		mt.AddMockResponses(countReply(t, 0), writeReply(1), writeReply(1), writeReply(1))
+		prepareData(mt.Client, mt.Coll)
+
+		// The second one finds them and leaves the collection alone
+		mt.AddMockResponses(countReply(t, 3))
+		prepareData(mt.Client, mt.Coll)
+
+		if inserts := sentCommands(mt, "insert"); len(inserts) != 3 {
+			t.Errorf("sent %d inserts, want 3", len(inserts))
+		}
+	})
+}
+
+// A catalog without any of the sample books is left alone as well
+func TestPrepareDataSkipsOtherBooks(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		mt.AddMockResponses(countReply(t, 1))
+		prepareData(mt.Client, mt.Coll)
+
+		if inserts := sentCommands(mt, "insert"); len(inserts) != 0 {
+			t.Errorf("sent %d inserts into a collection holding other books", len(inserts))
+		}
+		// Every book counts, not only the ones with a sample ISBN
+		match := sentCommands(mt, "aggregate")[0].Command.Lookup("pipeline").Array().Index(0).Value().Document()
+		filter := match.Lookup("$match").Document()
+		if elems, err := filter.Elements(); err != nil || len(elems) != 0 {
+			t.Errorf("counted with filter %s, want every book", filter)
+		}
+	})
+}
+
+func TestSeedFromEnvOff(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		t.Setenv("SEED_DATA", "false")
+		if err := seedFromEnv(mt.Client, mt.Coll); err != nil {
+			t.Fatal(err)
+		}
+		if started := mt.GetAllStartedEvents(); len(started) != 0 {
+			t.Errorf("sent %d commands with seeding off", len(started))
+		}
+	})
+}
+
+func TestSeedFromEnvInvalid(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		t.Setenv("SEED_DATA", "sometimes")
+		if err := seedFromEnv(mt.Client, mt.Coll); err == nil {
+			t.Error("an invalid SEED_DATA was accepted")
+		}
+	})
+}
+
+// Stores a book and reads it back, checking the field names in between
+func TestBookStoreRoundTrip(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		book := seedBooks()[1]
+		book.Tags = []string{"gothic"}
+		book.CoverURL = "https://example.org/frankenstein.jpg"
+
+		mt.AddMockResponses(writeReply(1))
+		if _, err := mt.Coll.InsertOne(context.TODO(), book); err != nil {
+			t.Fatal(err)
+		}
+		stored := insertedDoc(t, mt, 0)
+		for _, field := range []string{
+			"_id", "bookname", "bookauthor", "bookauthor_lower", "bookisbn", "bookisbn_digits",
+			"bookpages", "bookyear", "tags", "coverurl", "createdat", "updatedat",
+		} {
+			if _, err := stored.LookupErr(field); err != nil {
+				t.Errorf("the stored book %s lacks %s", stored, field)
+			}
+		}
+
+		mt.AddMockResponses(cursorReply(t, stored))
+		var found BookStore
+		if err := mt.Coll.FindOne(context.TODO(), bson.M{"_id": book.ID}).Decode(&found); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(found, book) {
+			t.Errorf("read back %+v, want %+v", found, book)
+		}
+	})
+}
+
+// Writes a self-signed certificate for 127.0.0.1 and its key into a
+// temporary directory
+func writeSelfSignedCert(t *testing.T) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestStartServerWithTLS(t *testing.T) {
+	certFile, keyFile := writeSelfSignedCert(t)
+	t.Setenv("TLS_CERT_FILE", certFile)
+	t.Setenv("TLS_KEY_FILE", keyFile)
+	certFile, keyFile, err := tlsFilesFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	e.HideBanner, e.HidePort = true, true
+	e.GET("/api/ping", func(c echo.Context) error {
+		return c.String(http.StatusOK, "pong")
+	})
+	go startServer(e, "127.0.0.1:0", certFile, keyFile)
+	t.Cleanup(func() { e.Close() })
+
+	deadline := time.Now().Add(5 * time.Second)
+	for e.TLSListenerAddr() == nil {
+		if e.ListenerAddr() != nil {
+			t.Fatal("the server was started without TLS")
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the TLS server did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The certificate is self-signed, so it is not checked here
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	resp, err := client.Get("https://" + e.TLSListenerAddr().String() + "/api/ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.TLS == nil {
+		t.Errorf("got %d over TLS %v, want 200 over TLS", resp.StatusCode, resp.TLS != nil)
+	}
+}
+
+// Moves the test into a directory of its own with a views folder holding
+// page.html, and returns the path of that file
+func writeTestView(t *testing.T, content string) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "views"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "views", "page.html")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	return path
+}
+
+func renderTestView(t *testing.T, tmpl *Template) (int, string) {
+	t.Helper()
+	e := echo.New()
+	e.Renderer = tmpl
+	e.HTTPErrorHandler = httpErrorHandler(e.DefaultHTTPErrorHandler)
+	e.GET("/page", func(c echo.Context) error {
+		return c.Render(http.StatusOK, "page", nil)
+	})
+	rec := serve(e, http.MethodGet, "/page", "")
+	return rec.Code, strings.TrimSpace(rec.Body.String())
+}
+
+// With DEV_MODE an edited view shows up on the next page, without it the
+// views stay as they were loaded
+func TestTemplateReload(t *testing.T) {
+	path := writeTestView(t, `{{ define "page" }}first{{ end }}`)
+	reloading := loadTemplates(true)
+	cached := loadTemplates(false)
+
+	if err := os.WriteFile(path, []byte(`{{ define "page" }}second{{ end }}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if status, body := renderTestView(t, reloading); status != http.StatusOK || body != "second" {
+		t.Errorf("with reload: got %d %q, want 200 \"second\"", status, body)
+	}
+	if status, body := renderTestView(t, cached); status != http.StatusOK || body != "first" {
+		t.Errorf("without reload: got %d %q, want 200 \"first\"", status, body)
+	}
+
+	// A broken edit answers with a 500 instead of taking the server down
+	if err := os.WriteFile(path, []byte(`{{ define "page" }}{{ .Name `), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if status, body := renderTestView(t, reloading); status != http.StatusInternalServerError || strings.Contains(body, "second") {
+		t.Errorf("broken view: got %d %q, want a 500", status, body)
+	}
+	if status, body := renderTestView(t, cached); status != http.StatusOK || body != "first" {
+		t.Errorf("broken view without reload: got %d %q, want 200 \"first\"", status, body)
+	}
+}
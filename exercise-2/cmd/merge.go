@@ -0,0 +1,62 @@
+package main
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type MergeDTO struct {
+	Keep   string `json:"keep"`
+	Remove string `json:"remove"`
+}
+
+type MergeResult struct {
+	Book    BookDTO  `json:"book"`
+	Filled  []string `json:"filled"`
+	Removed string   `json:"removed"`
+}
+
+// Fills the empty fields of keep with the values of remove. Fields keep
+// already has are never overwritten. Returns the merged book, the $set
+// document for the database and the names of the filled fields.
+func mergeBooks(keep BookStore, remove BookStore) (BookStore, bson.M, []string) {
+	set := bson.M{}
+	filled := make([]string, 0)
+	if keep.BookName == "" && remove.BookName != "" {
+		keep.BookName = remove.BookName
+		set["bookname"] = keep.BookName
+		filled = append(filled, "name")
+	}
+	if keep.BookAuthor == "" && remove.BookAuthor != "" {
+		keep.BookAuthor = remove.BookAuthor
+		set["bookauthor"] = keep.BookAuthor
+		set["bookauthor_lower"] = authorKey(keep.BookAuthor)
+		filled = append(filled, "author")
+	}
+	if keep.BookISBN == "" && remove.BookISBN != "" {
+		keep.BookISBN = remove.BookISBN
+		set["bookisbn"] = keep.BookISBN
+		set["bookisbn_digits"] = isbnKey(keep.BookISBN)
+		filled = append(filled, "isbn")
+	}
+	if keep.BookPages == 0 && remove.BookPages != 0 {
+		keep.BookPages = remove.BookPages
+		set["bookpages"] = keep.BookPages
+		filled = append(filled, "pages")
+	}
+	if keep.BookYear == 0 && remove.BookYear != 0 {
+		keep.BookYear = remove.BookYear
+		set["bookyear"] = keep.BookYear
+		filled = append(filled, "year")
+	}
+	if len(keep.Tags) == 0 && len(remove.Tags) > 0 {
+		keep.Tags = remove.Tags
+		set["tags"] = keep.Tags
+		filled = append(filled, "tags")
+	}
+	if keep.CoverURL == "" && remove.CoverURL != "" {
+		keep.CoverURL = remove.CoverURL
+		set["coverurl"] = keep.CoverURL
+		filled = append(filled, "coverUrl")
+	}
+	return keep, set, filled
+}
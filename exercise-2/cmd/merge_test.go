@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestMergePartialRecords(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		books := seedBooks()
+		keep, remove := books[1], books[2]
+		keep.BookISBN, keep.BookISBNDigits, keep.BookPages = "", "", 0
+		remove.BookName, remove.BookAuthor = "Frankenstein", "Mary Shelley"
+		remove.BookISBN, remove.BookISBNDigits = "978-3-649-64609-9", isbnKey("978-3-649-64609-9")
+		remove.BookYear = 1831
+
+		// Both finds, releasing the ISBN of the removed book, the update of
+		// the kept book, its history entry, the delete and the history entry
+		// of the removed book
+		mt.AddMockResponses(booksReply(t, keep), booksReply(t, remove),
+			writeReply(1), writeReply(1), writeReply(1), writeReply(1), writeReply(1))
+		rec := serve(e, http.MethodPost, "/api/books/merge",
+			`{"keep":"`+keep.ID.Hex()+`","remove":"`+remove.ID.Hex()+`"}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+		var result MergeResult
+		if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		book := result.Book
+		if book.Id != keep.ID.Hex() || book.Isbn != "978-3-649-64609-9" || book.Pages != 280 || book.Year != 1818 {
+			t.Errorf("merged book %+v, want the kept one with the ISBN and pages of the other", book)
+		}
+		if want := []string{"isbn", "pages"}; !slices.Equal(result.Filled, want) {
+			t.Errorf("filled %v, want %v", result.Filled, want)
+		}
+
+		// The ISBN index is unique, so the removed book gives up its ISBN
+		// before the kept one takes it
+		updates := sentCommands(mt, "update")
+		release := updates[0].Command.Lookup("updates").Array().Index(0).Value().Document()
+		if id := release.Lookup("q", "_id").ObjectID(); id != remove.ID {
+			t.Errorf("first updated %s, want the removed book %s", id.Hex(), remove.ID.Hex())
+		}
+		if _, err := release.LookupErr("u", "$unset", "bookisbn_digits"); err != nil {
+			t.Errorf("the removed book kept its ISBN: %s", release)
+		}
+		set := updates[1].Command.Lookup("updates").Array().Index(0).Value().Document().Lookup("u", "$set").Document()
+		if _, err := set.LookupErr("bookyear"); err == nil {
+			t.Errorf("the year the kept book has was overwritten: %s", set)
+		}
+		deleted := sentCommands(mt, "delete")[0].Command.Lookup("deletes").Array().Index(0).Value().Document()
+		if id := deleted.Lookup("q", "_id").ObjectID(); id != remove.ID {
+			t.Errorf("deleted %s, want %s", id.Hex(), remove.ID.Hex())
+		}
+	})
+}
+
+// Another book taking the ISBN in the meantime makes the update of the kept
+// book fail. The removed book then gets its ISBN back and is not deleted.
+func TestMergeDuplicateISBN(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		books := seedBooks()
+		keep, remove := books[1], books[2]
+		keep.BookISBN, keep.BookISBNDigits = "", ""
+
+		// Both finds, releasing the ISBN, the rejected update of the kept
+		// book and giving the ISBN back
+		mt.AddMockResponses(booksReply(t, keep), booksReply(t, remove),
+			writeReply(1), duplicateKeyReply(), writeReply(1))
+		rec := serve(e, http.MethodPost, "/api/books/merge",
+			`{"keep":"`+keep.ID.Hex()+`","remove":"`+remove.ID.Hex()+`"}`)
+		if rec.Code != http.StatusConflict {
+			t.Fatalf("status = %d, want 409: %s", rec.Code, rec.Body.String())
+		}
+		if deletes := sentCommands(mt, "delete"); len(deletes) != 0 {
+			t.Error("the removed book was deleted although the merge failed")
+		}
+		updates := sentCommands(mt, "update")
+		if len(updates) != 3 {
+			t.Fatalf("sent %d updates, want 3", len(updates))
+		}
+		restore := updates[2].Command.Lookup("updates").Array().Index(0).Value().Document()
+		if id := restore.Lookup("q", "_id").ObjectID(); id != remove.ID {
+			t.Errorf("gave the ISBN back to %s, want %s", id.Hex(), remove.ID.Hex())
+		}
+		if digits := restore.Lookup("u", "$set", "bookisbn_digits").StringValue(); digits != isbnKey(remove.BookISBN) {
+			t.Errorf("gave back %q, want %q", digits, isbnKey(remove.BookISBN))
+		}
+	})
+}
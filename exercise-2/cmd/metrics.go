@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Upper bounds (in seconds) of the request latency histogram. They are the
+// same defaults the official Prometheus client uses.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type requestKey struct {
+	method string
+	route  string
+	status int
+}
+
+type routeKey struct {
+	method string
+	route  string
+}
+
+type histogram struct {
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+// Metrics keeps the counters we expose to Prometheus. We only need a handful
+// of series, so instead of pulling the whole client library we keep them in
+// maps and write the text exposition format ourselves.
+// More on the format: https://prometheus.io/docs/instrumenting/exposition_formats/
+type Metrics struct {
+	mu        sync.Mutex
+	requests  map[requestKey]uint64
+	errors    map[routeKey]uint64
+	latencies map[routeKey]*histogram
+	coll      *mongo.Collection
+}
+
+// Creates the collectors once at startup. The collection is used to report
+// the current amount of books every time /metrics is scraped.
+func newMetrics(coll *mongo.Collection) *Metrics {
+	return &Metrics{
+		requests:  map[requestKey]uint64{},
+		errors:    map[routeKey]uint64{},
+		latencies: map[routeKey]*histogram{},
+		coll:      coll,
+	}
+}
+
+func (m *Metrics) observe(method string, route string, status int, elapsed time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests[requestKey{method, route, status}]++
+	if status >= http.StatusInternalServerError {
+		m.errors[routeKey{method, route}]++
+	}
+
+	h, ok := m.latencies[routeKey{method, route}]
+	if !ok {
+		h = &histogram{buckets: make([]uint64, len(latencyBuckets))}
+		m.latencies[routeKey{method, route}] = h
+	}
+	seconds := elapsed.Seconds()
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// Middleware records every request by its route template (e.g. /api/books/:id)
+// rather than the raw URL, so the amount of series stays bounded. It has to
+// run outside of recoverPanics, so panics are counted as the 500 they are
+// answered with.
+func (m *Metrics) Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			err := next(c)
+
+			// A returned error is only answered by echo's error handler
+			// after we are done, so take the status it will send from the
+			// error. The error itself is passed on untouched, for the
+			// logger and the error handler.
+			status := c.Response().Status
+			if err != nil && !c.Response().Committed {
+				status = http.StatusInternalServerError
+				var httpErr *echo.HTTPError
+				if errors.As(err, &httpErr) {
+					status = httpErr.Code
+				}
+			}
+
+			route := c.Path()
+			if route == "" {
+				route = "unmatched"
+			}
+			m.observe(c.Request().Method, route, status, time.Since(start))
+			return err
+		}
+	}
+}
+
+// Handler serves GET /metrics in the Prometheus text format.
+func (m *Metrics) Handler(c echo.Context) error {
+	var sb strings.Builder
+
+	m.mu.Lock()
+	sb.WriteString("# HELP http_requests_total Total number of HTTP requests by route and status.\n")
+	sb.WriteString("# TYPE http_requests_total counter\n")
+	requestKeys := make([]requestKey, 0, len(m.requests))
+	for k := range m.requests {
+		requestKeys = append(requestKeys, k)
+	}
+	sort.Slice(requestKeys, func(i, j int) bool {
+		a, b := requestKeys[i], requestKeys[j]
+		if a.route != b.route {
+			return a.route < b.route
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.status < b.status
+	})
+	for _, k := range requestKeys {
+		fmt.Fprintf(&sb, "http_requests_total{method=%q,route=%q,status=\"%d\"} %d\n", k.method, k.route, k.status, m.requests[k])
+	}
+
+	sb.WriteString("# HELP http_request_errors_total Total number of HTTP requests answered with a 5xx status.\n")
+	sb.WriteString("# TYPE http_request_errors_total counter\n")
+	for _, k := range sortedRouteKeys(m.errors) {
+		fmt.Fprintf(&sb, "http_request_errors_total{method=%q,route=%q} %d\n", k.method, k.route, m.errors[k])
+	}
+
+	sb.WriteString("# HELP http_request_duration_seconds Latency of HTTP requests by route.\n")
+	sb.WriteString("# TYPE http_request_duration_seconds histogram\n")
+	for _, k := range sortedRouteKeys(m.latencies) {
+		h := m.latencies[k]
+		for i, bound := range latencyBuckets {
+			fmt.Fprintf(&sb, "http_request_duration_seconds_bucket{method=%q,route=%q,le=\"%g\"} %d\n", k.method, k.route, bound, h.buckets[i])
+		}
+		fmt.Fprintf(&sb, "http_request_duration_seconds_bucket{method=%q,route=%q,le=\"+Inf\"} %d\n", k.method, k.route, h.count)
+		fmt.Fprintf(&sb, "http_request_duration_seconds_sum{method=%q,route=%q} %g\n", k.method, k.route, h.sum)
+		fmt.Fprintf(&sb, "http_request_duration_seconds_count{method=%q,route=%q} %d\n", k.method, k.route, h.count)
+	}
+	m.mu.Unlock()
+
+	// The gauge is read from the database on every scrape, so it is always
+	// in line with the collection even if books are added by other services.
+	ctx, cancel := context.WithTimeout(c.Request().Context(), 5*time.Second)
+	defer cancel()
+	count, err := m.coll.EstimatedDocumentCount(ctx)
+	if err == nil {
+		sb.WriteString("# HELP books_total Current number of books in the collection.\n")
+		sb.WriteString("# TYPE books_total gauge\n")
+		fmt.Fprintf(&sb, "books_total %d\n", count)
+	}
+
+	return c.Blob(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(sb.String()))
+}
+
+func sortedRouteKeys[V any](m map[routeKey]V) []routeKey {
+	keys := make([]routeKey, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		return keys[i].method < keys[j].method
+	})
+	return keys
+}
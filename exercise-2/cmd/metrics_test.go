@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestMetricsAfterRequests(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		metrics := newMetrics(mt.Coll)
+		e.Use(metrics.Middleware())
+		e.Use(recoverPanics())
+		e.GET("/metrics", metrics.Handler)
+		e.GET("/panic", func(c echo.Context) error {
+			panic("broken handler")
+		})
+
+		serve(e, http.MethodGet, "/api/ping", "")
+		serve(e, http.MethodGet, "/api/books/not-an-id", "")
+		serve(e, http.MethodGet, "/panic", "")
+
+		// Reply to EstimatedDocumentCount
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 3}))
+		rec := serve(e, http.MethodGet, "/metrics", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200", rec.Code)
+		}
+		body := rec.Body.String()
+		for _, want := range []string{
+			`http_requests_total{method="GET",route="/api/ping",status="200"} 1`,
+			`http_requests_total{method="GET",route="/api/books/:id",status="400"} 1`,
+			`http_requests_total{method="GET",route="/panic",status="500"} 1`,
+			`http_request_errors_total{method="GET",route="/panic"} 1`,
+			`http_request_duration_seconds_count{method="GET",route="/api/ping"} 1`,
+			`http_request_duration_seconds_bucket{method="GET",route="/api/ping",le="+Inf"} 1`,
+			"books_total 3",
+		} {
+			if !strings.Contains(body, want) {
+				t.Errorf("metrics lack %q:\n%s", want, body)
+			}
+		}
+	})
+}
+
+func TestMetricsPassErrorsOn(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		metrics := newMetrics(mt.Coll)
+		e.Use(metrics.Middleware())
+		e.GET("/metrics", metrics.Handler)
+
+		// The 404 of an unknown route is answered by the error handler, so
+		// it has to see the error
+		rec := serve(e, http.MethodGet, "/api/nonexistent", "")
+		if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), codeNotFound) {
+			t.Fatalf("got %d %s, want the 404 envelope", rec.Code, rec.Body.String())
+		}
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0}))
+		body := serve(e, http.MethodGet, "/metrics", "").Body.String()
+		if !strings.Contains(body, `status="404"} 1`) {
+			t.Errorf("the 404 was not recorded:\n%s", body)
+		}
+	})
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// Lets browsers keep static files for maxAge instead of fetching them on
+// every page load. With 0 they have to check back with us every time.
+// Errors are never cached, so a file added later shows up right away.
+func cacheControl(maxAge time.Duration) echo.MiddlewareFunc {
+	value := "no-cache"
+	if maxAge > 0 {
+		value = fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds()))
+	}
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Response().Before(func() {
+				if c.Response().Status < http.StatusBadRequest {
+					c.Response().Header().Set(echo.HeaderCacheControl, value)
+				}
+			})
+			return next(c)
+		}
+	}
+}
+
+// Sets X-Response-Time (e.g. "1.234ms") on every response, measured from the
+// moment the request reached this middleware until the headers are written.
+// The header has to be set right before they are sent, since afterwards
+// changes are ignored.
+func responseTime() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			c.Response().Before(func() {
+				elapsed := float64(time.Since(start).Microseconds()) / 1000
+				c.Response().Header().Set("X-Response-Time", fmt.Sprintf("%.3fms", elapsed))
+			})
+			return next(c)
+		}
+	}
+}
+
+// Turns a panic in a handler into a 500 with the usual error envelope
+// instead of a dropped connection. The stack trace goes to the log along
+// with the request ID, which the client also gets in the envelope.
+func recoverPanics() echo.MiddlewareFunc {
+	return middleware.RecoverWithConfig(middleware.RecoverConfig{
+		LogErrorFunc: func(c echo.Context, err error, stack []byte) error {
+			requestID := c.Response().Header().Get(echo.HeaderXRequestID)
+			log.Printf("error: panic in %s %s (request %s): %v\n%s", c.Request().Method, c.Path(), requestID, err, stack)
+			if !c.Response().Committed {
+				respondError(c, http.StatusInternalServerError, codeInternal, "internal server error")
+			}
+			// Already answered, so echo's error handler is not needed
+			return nil
+		},
+	})
+}
+
+// Collects what a handler writes for a HEAD request without sending it, so
+// the length of the body can be put into Content-Length afterwards
+type headWriter struct {
+	http.ResponseWriter
+	status int
+	length int
+}
+
+func (w *headWriter) WriteHeader(status int) {
+	w.status = status
+}
+
+func (w *headWriter) Write(b []byte) (int, error) {
+	w.length += len(b)
+	return len(b), nil
+}
+
+// Lets a GET handler answer HEAD requests as well: it runs as usual, but
+// only the headers are sent, with Content-Length set to the size the body
+// would have had. GET requests pass through untouched.
+func headWithoutBody() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if c.Request().Method != http.MethodHead {
+				return next(c)
+			}
+			res := c.Response()
+			original := res.Writer
+			w := &headWriter{ResponseWriter: original}
+			res.Writer = w
+			err := next(c)
+			res.Writer = original
+			if w.status != 0 {
+				res.Header().Set(echo.HeaderContentLength, strconv.Itoa(w.length))
+				original.WriteHeader(w.status)
+			}
+			return err
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestGzipResponses(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		e.Use(middleware.GzipWithConfig(middleware.GzipConfig{MinLength: 1}))
+
+		mt.AddMockResponses(booksReply(t, seedBooks()...))
+		rec := serve(e, http.MethodGet, "/api/books", "", "Accept-Encoding", "gzip")
+		if rec.Header().Get("Content-Encoding") != "gzip" {
+			t.Fatalf("Content-Encoding = %q, want gzip", rec.Header().Get("Content-Encoding"))
+		}
+		reader, err := gzip.NewReader(rec.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var books []BookDTO
+		if err = json.NewDecoder(reader).Decode(&books); err != nil || len(books) != 3 {
+			t.Errorf("decompressed %d books (%v), want 3", len(books), err)
+		}
+
+		// Clients that cannot decompress get the plain body
+		mt.AddMockResponses(booksReply(t, seedBooks()...))
+		if rec = serve(e, http.MethodGet, "/api/books", ""); rec.Header().Get("Content-Encoding") != "" {
+			t.Errorf("Content-Encoding = %q without Accept-Encoding", rec.Header().Get("Content-Encoding"))
+		}
+	})
+}
+
+func TestSecureHeaders(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		e.Use(middleware.SecureWithConfig(middleware.SecureConfig{
+			XSSProtection:         "1; mode=block",
+			ContentTypeNosniff:    "nosniff",
+			XFrameOptions:         "SAMEORIGIN",
+			ContentSecurityPolicy: defaultContentSecurityPolicy,
+		}))
+
+		rec := serve(e, http.MethodGet, "/", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200", rec.Code)
+		}
+		for header, want := range map[string]string{
+			"X-XSS-Protection":        "1; mode=block",
+			"X-Content-Type-Options":  "nosniff",
+			"X-Frame-Options":         "SAMEORIGIN",
+			"Content-Security-Policy": defaultContentSecurityPolicy,
+		} {
+			if got := rec.Header().Get(header); got != want {
+				t.Errorf("%s = %q, want %q", header, got, want)
+			}
+		}
+	})
+}
+
+func TestResponseTimeHeader(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		e.Use(responseTime())
+
+		rec := serve(e, http.MethodGet, "/api/ping", "")
+		elapsed, err := time.ParseDuration(rec.Header().Get("X-Response-Time"))
+		if err != nil || elapsed < 0 {
+			t.Errorf("X-Response-Time = %q, want a non-negative duration (%v)", rec.Header().Get("X-Response-Time"), err)
+		}
+	})
+}
+
+func TestSlowRequestTimesOut(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		e.Use(middleware.TimeoutWithConfig(middleware.TimeoutConfig{
+			Timeout:      10 * time.Millisecond,
+			ErrorMessage: errorJSON(codeTimeout, "the request took too long"),
+		}))
+		e.GET("/slow", func(c echo.Context) error {
+			<-c.Request().Context().Done()
+			return c.NoContent(http.StatusOK)
+		})
+
+		rec := serve(e, http.MethodGet, "/slow", "")
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Fatalf("status = %d, want 503", rec.Code)
+		}
+		if got := decodeEnvelope(t, rec.Body.Bytes()); got.Code != codeTimeout {
+			t.Errorf("code = %q, want %q", got.Code, codeTimeout)
+		}
+	})
+}
+
+func TestPanicAnswersWithEnvelope(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		e.Use(middleware.RequestID())
+		e.Use(recoverPanics())
+		e.GET("/panic", func(c echo.Context) error {
+			panic("broken handler")
+		})
+
+		rec := serve(e, http.MethodGet, "/panic", "")
+		if rec.Code != http.StatusInternalServerError {
+			t.Errorf("status = %d, want 500", rec.Code)
+		}
+		got := decodeEnvelope(t, rec.Body.Bytes())
+		if got.Code != codeInternal || got.RequestID == "" || got.RequestID != rec.Header().Get(echo.HeaderXRequestID) {
+			t.Errorf("got %+v, want %s with the request ID", got, codeInternal)
+		}
+	})
+}
+
+func TestCacheControlOnCSS(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		e.Group("/css", cacheControl(time.Hour)).Static("/", "css")
+
+		rec := serve(e, http.MethodGet, "/css/index.css", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200", rec.Code)
+		}
+		if got := rec.Header().Get(echo.HeaderCacheControl); got != "public, max-age=3600" {
+			t.Errorf("Cache-Control = %q, want public, max-age=3600", got)
+		}
+
+		// A missing file is not cached, it may be added later
+		rec = serve(e, http.MethodGet, "/css/missing.css", "")
+		if got := rec.Header().Get(echo.HeaderCacheControl); rec.Code != http.StatusNotFound || got != "" {
+			t.Errorf("got %d with Cache-Control %q, want an uncached 404", rec.Code, got)
+		}
+	})
+}
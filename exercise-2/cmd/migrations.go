@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// A change to the stored books, e.g. filling a new field for the books
+// stored before it existed. Run has to be idempotent: it is only recorded
+// as applied once it succeeded, so after a crash it runs again.
+type Migration struct {
+	// Recorded in the migrations collection once applied, never change it
+	ID  string
+	Run func(books *mongo.Collection) error
+}
+
+// Every migration, in the order they run. New ones go at the end.
+var migrations = []Migration{
+	{ID: "0001-bookauthor-lower", Run: func(books *mongo.Collection) error {
+		updated, err := backfillDerivedField(books, "bookauthor", "bookauthor_lower", authorKey)
+		if err == nil {
+			log.Printf("filled bookauthor_lower for %d books\n", updated)
+		}
+		return err
+	}},
+	{ID: "0002-timestamps", Run: func(books *mongo.Collection) error {
+		// Books from before createdat and updatedat were tracked get the
+		// time their id was generated, which is when they were inserted
+		created := bson.M{"$toDate": "$_id"}
+		result, err := books.UpdateMany(
+			context.TODO(),
+			bson.M{"$or": bson.A{
+				bson.M{"createdat": bson.M{"$exists": false}},
+				bson.M{"updatedat": bson.M{"$exists": false}},
+			}},
+			mongo.Pipeline{{{Key: "$set", Value: bson.M{
+				"createdat": bson.M{"$ifNull": bson.A{"$createdat", created}},
+				"updatedat": bson.M{"$ifNull": bson.A{"$updatedat", created}},
+			}}}},
+		)
+		if err == nil {
+			log.Printf("filled the timestamps of %d books\n", result.ModifiedCount)
+		}
+		return err
+	}},
+	{ID: "0003-bookisbn-digits", Run: func(books *mongo.Collection) error {
+		updated, err := backfillDerivedField(books, "bookisbn", "bookisbn_digits", isbnKey)
+		if err == nil {
+			log.Printf("filled bookisbn_digits for %d books\n", updated)
+		}
+		return err
+	}},
+}
+
+// Entry of the migrations collection
+type AppliedMigration struct {
+	ID        string    `bson:"_id"`
+	AppliedAt time.Time `bson:"appliedat"`
+}
+
+// Runs the migrations that were not applied to this database yet, in order,
+// and stops at the first one failing. The applied ones are kept in the
+// migrations collection, so each runs once.
+func runMigrations(db *mongo.Database, books *mongo.Collection, migrations []Migration) error {
+	applied := db.Collection("migrations")
+	for _, migration := range migrations {
+		err := applied.FindOne(context.TODO(), bson.M{"_id": migration.ID}).Err()
+		if err == nil {
+			continue
+		}
+		if err != mongo.ErrNoDocuments {
+			return err
+		}
+
+		log.Printf("running migration %s\n", migration.ID)
+		if err = migration.Run(books); err != nil {
+			return err
+		}
+		// Another instance starting at the same time may have recorded it
+		// already, which is fine as migrations are idempotent
+		_, err = applied.InsertOne(context.TODO(), AppliedMigration{ID: migration.ID, AppliedAt: time.Now().UTC()})
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestMigrationRunsOnce(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		runs := 0
+		fake := []Migration{{ID: "9999-fake", Run: func(books *mongo.Collection) error {
+			runs++
+			return nil
+		}}}
+
+		// First startup: not applied yet, so it runs and gets recorded
+		mt.AddMockResponses(cursorReply(t), writeReply(1))
+		if err := runMigrations(mt.DB, mt.Coll, fake); err != nil {
+			t.Fatal(err)
+		}
+		recorded := insertedDoc(t, mt, 0)
+		if id := recorded.Lookup("_id").StringValue(); id != "9999-fake" {
+			t.Fatalf("recorded %s, want the fake migration", recorded)
+		}
+
+		// Second startup: the lookup finds what the first one recorded
+		mt.AddMockResponses(cursorReply(t, recorded))
+		if err := runMigrations(mt.DB, mt.Coll, fake); err != nil {
+			t.Fatal(err)
+		}
+		if runs != 1 {
+			t.Errorf("the migration ran %d times, want once", runs)
+		}
+		if inserts := sentCommands(mt, "insert"); len(inserts) != 1 {
+			t.Errorf("recorded the migration %d times, want once", len(inserts))
+		}
+	})
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Logs every database command taking longer than the threshold, together
+// with the app name, so it can be matched with the server-side profiler.
+// More on command monitoring: https://www.mongodb.com/docs/drivers/go/current/fundamentals/monitoring/command-monitoring/
+func slowCommandMonitor(appName string, threshold time.Duration) *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Succeeded: func(_ context.Context, evt *event.CommandSucceededEvent) {
+			if evt.Duration > threshold {
+				log.Printf("warning: slow MongoDB command %s on %s took %v (app %s)\n", evt.CommandName, evt.DatabaseName, evt.Duration, appName)
+			}
+		},
+		Failed: func(_ context.Context, evt *event.CommandFailedEvent) {
+			if evt.Duration > threshold {
+				log.Printf("warning: slow MongoDB command %s on %s failed after %v (app %s): %s\n", evt.CommandName, evt.DatabaseName, evt.Duration, appName, evt.Failure)
+			}
+		},
+	}
+}
+
+// Set through SLOW_QUERY_THRESHOLD (default 500ms, 0 turns it off), see
+// timeQuery
+var slowQueryThreshold = 500 * time.Millisecond
+
+// Measures a query of our own, from sending it until the last result was
+// read, and logs it with its filter when it took longer than
+// slowQueryThreshold. Unlike slowCommandMonitor, which sees single driver
+// commands, this tells which of our queries was slow. Meant to be deferred:
+//
+//	defer timeQuery(coll, "find", filter)()
+func timeQuery(coll *mongo.Collection, operation string, filter interface{}) func() {
+	start := time.Now()
+	return func() {
+		if elapsed := time.Since(start); slowQueryThreshold > 0 && elapsed > slowQueryThreshold {
+			log.Printf("warning: slow %s on %s took %v, filter %v\n", operation, coll.Name(), elapsed, filter)
+		}
+	}
+}
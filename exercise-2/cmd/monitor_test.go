@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestSlowQueryLogged(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		var logs bytes.Buffer
+		log.SetOutput(&logs)
+		defer log.SetOutput(os.Stderr)
+		defer func(threshold time.Duration) { slowQueryThreshold = threshold }(slowQueryThreshold)
+		slowQueryThreshold = 20 * time.Millisecond
+
+		// A query answered right away is not worth a warning
+		timeQuery(mt.Coll, "find", bson.M{"bookyear": 1818})()
+		if logs.Len() != 0 {
+			t.Errorf("a fast query was logged: %s", logs.String())
+		}
+
+		// The database taking its time over a query
+		done := timeQuery(mt.Coll, "find", bson.M{"bookauthor": "Mary Shelley"})
+		time.Sleep(2 * slowQueryThreshold)
+		done()
+		line := logs.String()
+		if !strings.Contains(line, "warning: slow find on "+mt.Coll.Name()) || !strings.Contains(line, "Mary Shelley") {
+			t.Errorf("got %q, want a warning naming the query and its filter", line)
+		}
+	})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/xml"
+	"mime"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Response formats we can produce
+const (
+	formatJSON = "json"
+	formatXML  = "xml"
+)
+
+// Picks the response format from the Accept header, honouring q-values.
+// JSON is the default (no header, */* or application/json), XML is used
+// when it is weighted higher than JSON. A type with q=0 is excluded. The
+// second value is false when none of the accepted types can be served.
+func negotiateFormat(c echo.Context) (string, bool) {
+	accept := c.Request().Header.Get(echo.HeaderAccept)
+	if strings.TrimSpace(accept) == "" {
+		return formatJSON, true
+	}
+
+	// Every format gets the weight of the most specific range matching it,
+	// so "application/xml;q=0, */*" excludes XML but still allows JSON
+	weights := map[string]float64{}
+	specificity := map[string]int{}
+	weigh := func(format string, q float64, specific int) {
+		if specific > specificity[format] {
+			weights[format], specificity[format] = q, specific
+		}
+	}
+	preferred := 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil || q < 0 || q > 1 {
+				continue
+			}
+		}
+		preferred = max(preferred, q)
+		switch mediaType {
+		case "application/json":
+			weigh(formatJSON, q, 3)
+		case "application/xml", "text/xml":
+			weigh(formatXML, q, 3)
+		case "application/*":
+			weigh(formatJSON, q, 2)
+			weigh(formatXML, q, 2)
+		case "*/*":
+			weigh(formatJSON, q, 1)
+			weigh(formatXML, q, 1)
+		}
+	}
+
+	jsonQ, xmlQ := weights[formatJSON], weights[formatXML]
+	switch {
+	case jsonQ == 0 && xmlQ == 0:
+		return "", false
+	case jsonQ < preferred && xmlQ < preferred && jsonQ > 0:
+		// The client prefers something we cannot serve at all, like a
+		// browser asking for HTML first. The order of its fallbacks says
+		// little about what it wants, so it gets the default.
+		return formatJSON, true
+	case xmlQ > jsonQ:
+		return formatXML, true
+	default:
+		return formatJSON, true
+	}
+}
+
+// Root element for a list of books in XML, since XML documents need a
+// single root unlike JSON arrays
+type BookListXML struct {
+	XMLName xml.Name  `xml:"books"`
+	Books   []BookDTO `xml:"BookDTO"`
+}
+
+// Indents every JSON response, for JSON_PRETTY=true. Without it the output
+// stays compact, and a single response can still be indented with ?pretty,
+// which echo supports out of the box.
+type prettyJSONSerializer struct {
+	echo.DefaultJSONSerializer
+}
+
+func (s prettyJSONSerializer) Serialize(c echo.Context, i interface{}, indent string) error {
+	return s.DefaultJSONSerializer.Serialize(c, i, "  ")
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestBooksAsXML(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+
+		mt.AddMockResponses(booksReply(t, seedBooks()...))
+		rec := serve(e, http.MethodGet, "/api/books", "", "Accept", "application/xml")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+		if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/xml") {
+			t.Errorf("Content-Type = %q, want application/xml", ct)
+		}
+		var list BookListXML
+		if err := xml.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+			t.Fatalf("body is not well-formed XML: %v\n%s", err, rec.Body.String())
+		}
+		if len(list.Books) != 3 || list.Books[1].Name != "Frankenstein" || list.Books[1].Author != "Mary Shelley" {
+			t.Errorf("got %+v, want the three seed books", list.Books)
+		}
+		if !strings.Contains(rec.Body.String(), "<BookDTO>") {
+			t.Errorf("body lacks <BookDTO> elements:\n%s", rec.Body.String())
+		}
+	})
+}
+
+func TestUnsupportedAccept(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+
+		rec := serve(e, http.MethodGet, "/api/books", "", "Accept", "text/csv")
+		if rec.Code != http.StatusNotAcceptable {
+			t.Errorf("status = %d, want 406", rec.Code)
+		}
+	})
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		accept     string
+		wantFormat string
+		wantOK     bool
+	}{
+		{"", formatJSON, true},
+		{"application/xml", formatXML, true},
+		// What browsers send when a URL is opened
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", formatJSON, true},
+		{"application/json;q=0.1, application/xml;q=1", formatXML, true},
+		{"application/xml;q=0.5, application/json", formatJSON, true},
+		{"application/xml;q=0, */*", formatJSON, true},
+		{"application/json;q=0, application/xml;q=0", "", false},
+		{"text/csv", "", false},
+	}
+	for _, test := range tests {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodGet, "/api/books", nil)
+		req.Header.Set(echo.HeaderAccept, test.accept)
+		format, ok := negotiateFormat(e.NewContext(req, httptest.NewRecorder()))
+		if format != test.wantFormat || ok != test.wantOK {
+			t.Errorf("Accept %q: got %q, %v, want %q, %v", test.accept, format, ok, test.wantFormat, test.wantOK)
+		}
+	}
+}
+
+func TestPrettyJSON(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+
+		if body := serve(e, http.MethodGet, "/api/ping", "").Body.String(); strings.Contains(body, "\n  ") {
+			t.Errorf("body %q is indented without the flag", body)
+		}
+		e.JSONSerializer = prettyJSONSerializer{}
+		if body := serve(e, http.MethodGet, "/api/ping", "").Body.String(); !strings.HasPrefix(body, "{\n  \"service\": ") {
+			t.Errorf("body %q, want it indented by two spaces", body)
+		}
+	})
+}
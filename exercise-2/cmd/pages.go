@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type PageUpdate struct {
+	Isbn  string `json:"isbn"`
+	Pages int    `json:"pages"`
+}
+
+type PageUpdateResult struct {
+	Index   int    `json:"index"`
+	Isbn    string `json:"isbn"`
+	Updated bool   `json:"updated"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Sets the page counts of the books with the given ISBNs in a single round
+// trip and reports for every entry whether it was applied. Entries with an
+// invalid ISBN or page count, or for a book we do not have, are reported
+// without being sent to the database.
+func updatePagesByISBN(coll *mongo.Collection, updates []PageUpdate, limits BookLimits) ([]PageUpdateResult, error) {
+	results := make([]PageUpdateResult, len(updates))
+	seen := map[string]bool{}
+	keys := make([]string, 0, len(updates))
+	for i, update := range updates {
+		results[i] = PageUpdateResult{Index: i, Isbn: update.Isbn}
+		key := isbnKey(update.Isbn)
+		switch {
+		case !isValidISBN(update.Isbn):
+			results[i].Error = "invalid isbn"
+		case update.Pages < 0:
+			results[i].Error = "pages must not be negative"
+		case update.Pages > limits.MaxPages:
+			results[i].Error = fmt.Sprintf("pages must not be more than %d", limits.MaxPages)
+		case seen[key]:
+			results[i].Error = "isbn is listed more than once"
+		default:
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return results, nil
+	}
+
+	// The bulk write only tells how many books matched in total, so look up
+	// beforehand which ISBNs we have
+	filter := bson.M{"bookisbn_digits": bson.M{"$in": keys}}
+	cursor, err := coll.Find(context.TODO(), filter, options.Find().SetProjection(bson.M{"bookisbn_digits": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var found []BookStore
+	if err = cursor.All(context.TODO(), &found); err != nil {
+		return nil, err
+	}
+	existing := map[string]bool{}
+	for _, book := range found {
+		existing[book.BookISBNDigits] = true
+	}
+
+	now := time.Now().UTC()
+	models := make([]mongo.WriteModel, 0, len(keys))
+	positions := make([]int, 0, len(keys))
+	for i, update := range updates {
+		if results[i].Error != "" {
+			continue
+		}
+		if !existing[isbnKey(update.Isbn)] {
+			results[i].Error = "book does not exist"
+			continue
+		}
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(isbnFilter(update.Isbn)).
+			SetUpdate(bson.M{"$set": bson.M{"bookpages": update.Pages, "updatedat": now}}))
+		positions = append(positions, i)
+	}
+	if len(models) == 0 {
+		return results, nil
+	}
+
+	// Unordered, so one failing update does not stop the rest
+	_, err = coll.BulkWrite(context.TODO(), models, options.BulkWrite().SetOrdered(false))
+	failed := map[int]string{}
+	var writeErr mongo.BulkWriteException
+	if errors.As(err, &writeErr) && writeErr.WriteConcernError == nil {
+		for _, we := range writeErr.WriteErrors {
+			failed[positions[we.Index]] = we.Message
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	for _, i := range positions {
+		if message, ok := failed[i]; ok {
+			results[i].Error = message
+		} else {
+			results[i].Updated = true
+		}
+	}
+	return results, nil
+}
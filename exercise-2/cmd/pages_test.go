@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestBulkPagesByISBN(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		books := seedBooks()
+
+		// The lookup of the ISBNs we have and the bulk write of both updates
+		mt.AddMockResponses(
+			cursorReply(t, bson.M{"_id": books[0].ID, "bookisbn_digits": books[0].BookISBNDigits},
+				bson.M{"_id": books[1].ID, "bookisbn_digits": books[1].BookISBNDigits}),
+			writeReply(2),
+		)
+		rec := serve(e, http.MethodPatch, "/api/books/bulk-pages", `[
+			{"isbn":"958-30-0804-4","pages":300},
+			{"isbn":"9783649646099","pages":288},
+			{"isbn":"978-0-14-143984-6","pages":418}
+		]`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+		var results []PageUpdateResult
+		if err := json.Unmarshal(rec.Body.Bytes(), &results); err != nil {
+			t.Fatal(err)
+		}
+		want := []PageUpdateResult{
+			{Index: 0, Isbn: "958-30-0804-4", Updated: true},
+			{Index: 1, Isbn: "9783649646099", Updated: true},
+			{Index: 2, Isbn: "978-0-14-143984-6", Error: "book does not exist"},
+		}
+		if !reflect.DeepEqual(results, want) {
+			t.Errorf("results %+v, want %+v", results, want)
+		}
+
+		// One update per known book, found by the ISBN digits whatever the
+		// hyphenation
+		values, err := sentCommands(mt, "update")[0].Command.Lookup("updates").Array().Values()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(values) != 2 {
+			t.Fatalf("sent %d updates, want 2", len(values))
+		}
+		for i, pages := range []int32{300, 288} {
+			update := values[i].Document()
+			if digits := update.Lookup("q", "bookisbn_digits").StringValue(); digits != books[i].BookISBNDigits {
+				t.Errorf("update %d filters on %q, want %q", i, digits, books[i].BookISBNDigits)
+			}
+			if got := update.Lookup("u", "$set", "bookpages").Int32(); got != pages {
+				t.Errorf("update %d sets %d pages, want %d", i, got, pages)
+			}
+		}
+	})
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const mimeMergePatch = "application/merge-patch+json"
+
+// Fields a merge patch may change, by their JSON name, with their stored
+// name. Name and author are required, so they cannot be removed.
+var (
+	patchFields = map[string]string{
+		"name":     "bookname",
+		"author":   "bookauthor",
+		"isbn":     "bookisbn",
+		"pages":    "bookpages",
+		"year":     "bookyear",
+		"tags":     "tags",
+		"coverUrl": "coverurl",
+	}
+	patchRequired = []string{"name", "author"}
+)
+
+// Turns a JSON Merge Patch for a book, e.g. {"pages": 300, "isbn": null},
+// into $set and $unset. Fields that are left out stay as they are, null
+// removes a field and any other value replaces it. Unknown fields, values
+// of the wrong type and null for a required field are reported as errors.
+// More on the format: https://www.rfc-editor.org/rfc/rfc7386
+func mergePatchUpdate(raw map[string]json.RawMessage) (bson.M, bson.M, []FieldError) {
+	set, unset := bson.M{}, bson.M{}
+	var errs []FieldError
+
+	fields := make([]string, 0, len(raw))
+	for field := range raw {
+		fields = append(fields, field)
+	}
+	// Sorted, so the errors always come in the same order
+	slices.Sort(fields)
+	for _, field := range fields {
+		stored, ok := patchFields[field]
+		if !ok {
+			errs = append(errs, FieldError{Field: field, Message: "cannot be changed"})
+			continue
+		}
+		value := raw[field]
+		if strings.TrimSpace(string(value)) == "null" {
+			if slices.Contains(patchRequired, field) {
+				errs = append(errs, FieldError{Field: field, Message: "cannot be removed"})
+			} else {
+				unset[stored] = ""
+			}
+			continue
+		}
+
+		var err error
+		switch field {
+		case "pages", "year":
+			var number int
+			if err = json.Unmarshal(value, &number); err == nil {
+				set[stored] = number
+			}
+		case "tags":
+			var tags []string
+			if err = json.Unmarshal(value, &tags); err == nil {
+				set[stored] = normalizeTags(tags)
+			}
+		case "coverUrl":
+			var text string
+			if err = json.Unmarshal(value, &text); err == nil {
+				set[stored] = strings.TrimSpace(text)
+			}
+		default:
+			var text string
+			if err = json.Unmarshal(value, &text); err == nil {
+				set[stored] = normalizeText(text)
+			}
+		}
+		if err != nil {
+			errs = append(errs, FieldError{Field: field, Message: "has the wrong type"})
+		}
+	}
+	if author, ok := set["bookauthor"].(string); ok {
+		set["bookauthor_lower"] = authorKey(author)
+	}
+	if isbn, ok := set["bookisbn"].(string); ok {
+		set["bookisbn_digits"] = isbnKey(isbn)
+	}
+	if _, ok := unset["bookisbn"]; ok {
+		unset["bookisbn_digits"] = ""
+	}
+	return set, unset, errs
+}
+
+// Returns the book as it looks after the update, so it can be validated
+// before it is stored
+func patchedBook(book BookStore, set bson.M, unset bson.M) (BookStore, error) {
+	raw, err := bson.Marshal(book)
+	if err != nil {
+		return BookStore{}, err
+	}
+	var doc bson.M
+	if err = bson.Unmarshal(raw, &doc); err != nil {
+		return BookStore{}, err
+	}
+	for field, value := range set {
+		doc[field] = value
+	}
+	for field := range unset {
+		delete(doc, field)
+	}
+	raw, err = bson.Marshal(doc)
+	if err != nil {
+		return BookStore{}, err
+	}
+	var patched BookStore
+	err = bson.Unmarshal(raw, &patched)
+	return patched, err
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestPatchNullRemovesISBN(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		book := seedBooks()[1]
+
+		// The current book, the update and its history entry
+		mt.AddMockResponses(booksReply(t, book), findAndModifyReply(t, book), writeReply(1))
+		rec := serve(e, http.MethodPatch, "/api/books/"+book.ID.Hex(), `{"isbn": null}`,
+			echo.HeaderContentType, mimeMergePatch)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+		var got BookDTO
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Isbn != "" || got.Name != "Frankenstein" {
+			t.Errorf("got %+v, want Frankenstein without an ISBN", got)
+		}
+
+		update := sentCommands(mt, "findAndModify")[0].Command.Lookup("update").Document()
+		for _, field := range []string{"bookisbn", "bookisbn_digits"} {
+			if _, err := update.LookupErr("$unset", field); err != nil {
+				t.Errorf("update %s does not remove %s", update, field)
+			}
+			if _, err := update.LookupErr("$set", field); err == nil {
+				t.Errorf("update %s sets %s", update, field)
+			}
+		}
+	})
+}
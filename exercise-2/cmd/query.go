@@ -0,0 +1,420 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Conditions for a book lacking a piece of metadata, by the name used in
+// /api/books/incomplete?field=. Matching null also finds books where the
+// field is missing altogether.
+var incompleteFilters = map[string]bson.M{
+	"isbn":  {"bookisbn": bson.M{"$in": bson.A{"", nil}}},
+	"pages": {"bookpages": bson.M{"$in": bson.A{0, nil}}},
+	"year":  {"bookyear": bson.M{"$in": bson.A{0, nil}}},
+}
+
+// Builds the filter for /api/books/incomplete: books lacking the given
+// field, or any of them when field is empty
+func incompleteFilter(field string) (bson.M, error) {
+	if field != "" {
+		filter, ok := incompleteFilters[field]
+		if !ok {
+			return nil, fmt.Errorf("field must be isbn, pages or year")
+		}
+		return filter, nil
+	}
+	conditions := bson.A{}
+	for _, name := range []string{"isbn", "pages", "year"} {
+		conditions = append(conditions, incompleteFilters[name])
+	}
+	return bson.M{"$or": conditions}, nil
+}
+
+// Upper bound for ?isbns=, so one request cannot ask for the whole catalog
+const maxISBNsPerQuery = 50
+
+// Builds the filter for GET /api/books from its query parameters:
+//   - name: case-insensitive partial match on the book name
+//   - tag: books carrying the given tag
+//   - isbns: comma-separated list of ISBNs, at most maxISBNsPerQuery
+func bookFilterFromQuery(c echo.Context) (bson.M, error) {
+	filter := bson.M{}
+	if name := c.QueryParam("name"); name != "" {
+		// The input is escaped, so characters like ".*" are matched
+		// literally and cannot be used to build expensive patterns
+		filter["bookname"] = primitive.Regex{Pattern: regexp.QuoteMeta(name), Options: "i"}
+	}
+	if tag := c.QueryParam("tag"); tag != "" {
+		// Tags are stored lowercase, see normalizeTags
+		filter["tags"] = strings.ToLower(normalizeText(tag))
+	}
+	if raw := c.QueryParam("isbns"); raw != "" {
+		isbns := make([]string, 0)
+		for _, isbn := range strings.Split(raw, ",") {
+			if isbn = strings.TrimSpace(isbn); isbn != "" && !slices.Contains(isbns, isbn) {
+				isbns = append(isbns, isbn)
+			}
+		}
+		if len(isbns) > maxISBNsPerQuery {
+			return nil, fmt.Errorf("isbns accepts at most %d values", maxISBNsPerQuery)
+		}
+		for i, isbn := range isbns {
+			isbns[i] = isbnKey(isbn)
+		}
+		filter["bookisbn_digits"] = bson.M{"$in": isbns}
+	}
+	return filter, nil
+}
+
+// Builds the filter for GET /api/books/query, which combines a full text
+// search with structured filters:
+//   - q: words to look for in the name and author (required), see the text
+//     index in bookIndexes
+//   - minYear, maxYear: published in these years or between them
+//   - author: the author, ignoring case
+func textQueryFromQuery(c echo.Context) (bson.M, error) {
+	q := strings.TrimSpace(c.QueryParam("q"))
+	if q == "" {
+		return nil, fmt.Errorf("q is required")
+	}
+	conditions := bson.A{bson.M{"$text": bson.M{"$search": q}}}
+
+	years := bson.M{}
+	for _, bound := range []struct{ param, operator string }{{"minYear", "$gte"}, {"maxYear", "$lte"}} {
+		param, operator := bound.param, bound.operator
+		raw := c.QueryParam(param)
+		if raw == "" {
+			continue
+		}
+		year, err := strconv.Atoi(raw)
+		if err != nil || year < 0 {
+			return nil, fmt.Errorf("%s must be a non-negative number", param)
+		}
+		years[operator] = year
+	}
+	if len(years) > 0 {
+		conditions = append(conditions, bson.M{"bookyear": years})
+	}
+	if author := c.QueryParam("author"); author != "" {
+		conditions = append(conditions, bson.M{"bookauthor_lower": authorKey(normalizeText(author))})
+	}
+	return bson.M{"$and": conditions}, nil
+}
+
+// Maps the names clients may sort by to the stored field names
+var sortFields = map[string]string{
+	"name":      "bookname",
+	"author":    "bookauthor",
+	"year":      "bookyear",
+	"pages":     "bookpages",
+	"createdAt": "createdat",
+	"updatedAt": "updatedat",
+}
+
+// Builds the find options for ?sort=<field>&order=<asc|desc>, comparing
+// strings by the given collation. Without a sort parameter the books come
+// back in their natural order.
+func sortOptionsFromQuery(c echo.Context, collation *options.Collation) (*options.FindOptions, error) {
+	opts := options.Find()
+	sort := c.QueryParam("sort")
+	if sort == "" {
+		return opts, nil
+	}
+	field, ok := sortFields[sort]
+	if !ok {
+		return nil, fmt.Errorf("cannot sort by %q", sort)
+	}
+
+	direction := 1
+	switch c.QueryParam("order") {
+	case "", "asc":
+	case "desc":
+		direction = -1
+	default:
+		return nil, fmt.Errorf("order must be asc or desc")
+	}
+	// _id breaks ties, so books with equal values keep a stable order
+	return opts.SetCollation(collation).SetSort(bson.D{{Key: field, Value: direction}, {Key: "_id", Value: direction}}), nil
+}
+
+// Maps the names clients may pick with ?fields= to the stored field names.
+// The id is always returned, so it is not listed.
+var projectionFields = map[string]string{
+	"name":      "bookname",
+	"author":    "bookauthor",
+	"pages":     "bookpages",
+	"year":      "bookyear",
+	"isbn":      "bookisbn",
+	"tags":      "tags",
+	"coverUrl":  "coverurl",
+	"createdAt": "createdat",
+	"updatedAt": "updatedat",
+}
+
+// Reads ?fields=name,author and returns the projection loading only those
+// fields, along with the requested names. Without the parameter both are
+// nil and the whole book is loaded.
+func fieldsFromQuery(c echo.Context) (bson.M, []string, error) {
+	raw := c.QueryParam("fields")
+	if raw == "" {
+		return nil, nil, nil
+	}
+	projection := bson.M{}
+	fields := []string{"id"}
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || name == "id" {
+			continue
+		}
+		field, ok := projectionFields[name]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown field %q", name)
+		}
+		projection[field] = 1
+		fields = append(fields, name)
+	}
+	if len(projection) == 0 {
+		// Only the id was asked for
+		projection["_id"] = 1
+	}
+	return projection, fields, nil
+}
+
+// Keeps only the given fields of the book, so the ones left out are missing
+// from the JSON instead of showing up with a zero value.
+func pickFields(book BookDTO, fields []string) (map[string]interface{}, error) {
+	data, err := json.Marshal(book)
+	if err != nil {
+		return nil, err
+	}
+	all := map[string]interface{}{}
+	if err = json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+	picked := make(map[string]interface{}, len(fields))
+	for _, name := range fields {
+		if value, ok := all[name]; ok {
+			picked[name] = value
+		}
+	}
+	return picked, nil
+}
+
+// Page size used when only an offset is given, and the largest page a
+// client may ask for. Set through DEFAULT_PAGE_SIZE and MAX_PAGE_SIZE.
+type PageSizes struct {
+	Default int
+	Max     int
+}
+
+func pageSizesFromEnv() (PageSizes, error) {
+	defaultSize, err := intFromEnv("DEFAULT_PAGE_SIZE", 20)
+	if err != nil {
+		return PageSizes{}, err
+	}
+	maxSize, err := intFromEnv("MAX_PAGE_SIZE", 100)
+	if err != nil {
+		return PageSizes{}, err
+	}
+	if defaultSize < 1 || maxSize < 1 {
+		return PageSizes{}, fmt.Errorf("DEFAULT_PAGE_SIZE and MAX_PAGE_SIZE must be positive")
+	}
+	if defaultSize > maxSize {
+		return PageSizes{}, fmt.Errorf("DEFAULT_PAGE_SIZE (%d) must not be larger than MAX_PAGE_SIZE (%d)", defaultSize, maxSize)
+	}
+	return PageSizes{Default: defaultSize, Max: maxSize}, nil
+}
+
+type Page struct {
+	Offset int
+	Limit  int
+}
+
+// Reads ?offset= and ?limit=. The second return value reports whether the
+// client asked for pagination at all, so plain listings keep working.
+func pageFromQuery(c echo.Context, sizes PageSizes) (Page, bool, error) {
+	rawOffset, rawLimit := c.QueryParam("offset"), c.QueryParam("limit")
+	if rawOffset == "" && rawLimit == "" {
+		return Page{}, false, nil
+	}
+
+	page := Page{Limit: sizes.Default}
+	if rawOffset != "" {
+		offset, err := strconv.Atoi(rawOffset)
+		if err != nil || offset < 0 {
+			return Page{}, true, fmt.Errorf("offset must be a non-negative number")
+		}
+		page.Offset = offset
+	}
+	if rawLimit != "" {
+		limit, err := strconv.Atoi(rawLimit)
+		if err != nil || limit <= 0 {
+			return Page{}, true, fmt.Errorf("limit must be a positive number")
+		}
+		page.Limit = min(limit, sizes.Max)
+	}
+	return page, true, nil
+}
+
+// Upper bound for ?bounds=, so one request cannot ask for countless ranges
+const maxPageBounds = 20
+
+// Reads ?bounds= of /api/stats/page-buckets: comma-separated, increasing
+// upper bounds of the page ranges, e.g. 100,200,300 (the default)
+func pageBoundsFromQuery(c echo.Context) ([]int, error) {
+	raw := c.QueryParam("bounds")
+	if raw == "" {
+		return defaultPageBounds, nil
+	}
+	parts := strings.Split(raw, ",")
+	if len(parts) > maxPageBounds {
+		return nil, fmt.Errorf("bounds accepts at most %d values", maxPageBounds)
+	}
+	bounds := make([]int, 0, len(parts))
+	for _, part := range parts {
+		bound, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || bound < 1 {
+			return nil, fmt.Errorf("bounds must be positive numbers")
+		}
+		if len(bounds) > 0 && bound <= bounds[len(bounds)-1] {
+			return nil, fmt.Errorf("bounds must be increasing")
+		}
+		bounds = append(bounds, bound)
+	}
+	return bounds, nil
+}
+
+// Set on responses cut off by capResults
+const headerResultTruncated = "X-Result-Truncated"
+
+// Reads MAX_RESULTS, the most entries a listing without pagination returns
+// (default 10000, 0 for no limit). It keeps a huge collection from turning
+// into an equally huge response.
+func maxResultsFromEnv() (int, error) {
+	maxResults, err := intFromEnv("MAX_RESULTS", 10000)
+	if err != nil {
+		return 0, err
+	}
+	if maxResults < 0 {
+		return 0, fmt.Errorf("MAX_RESULTS must not be negative")
+	}
+	return maxResults, nil
+}
+
+// Cuts the results down to max entries. The response then carries
+// X-Result-Truncated: true, so clients know to narrow their query or to
+// page through the results.
+func capResults[T any](c echo.Context, results []T, max int) []T {
+	if max > 0 && len(results) > max {
+		c.Response().Header().Set(headerResultTruncated, "true")
+		return results[:max]
+	}
+	return results
+}
+
+type PageLinks struct {
+	Next *string `json:"next" xml:"next,omitempty"`
+	Prev *string `json:"prev" xml:"prev,omitempty"`
+}
+
+type PagedBooks struct {
+	XMLName xml.Name  `json:"-" xml:"books"`
+	Data    []BookDTO `json:"data" xml:"data>BookDTO"`
+	Total   int64     `json:"total" xml:"total"`
+	Links   PageLinks `json:"links" xml:"links"`
+}
+
+// Builds the links to the neighbouring pages, keeping every other query
+// parameter of the current request. A link is nil at the boundaries.
+func pageLinks(c echo.Context, page Page, total int64) PageLinks {
+	var links PageLinks
+	if int64(page.Offset+page.Limit) < total {
+		next := pageURL(c, page.Offset+page.Limit, page.Limit)
+		links.Next = &next
+	}
+	if page.Offset > 0 {
+		prev := pageURL(c, max(page.Offset-page.Limit, 0), page.Limit)
+		links.Prev = &prev
+	}
+	return links
+}
+
+func pageURL(c echo.Context, offset int, limit int) string {
+	query := url.Values{}
+	for key, values := range c.QueryParams() {
+		query[key] = values
+	}
+	query.Set("offset", strconv.Itoa(offset))
+	query.Set("limit", strconv.Itoa(limit))
+
+	u := url.URL{
+		Scheme:   c.Scheme(),
+		Host:     c.Request().Host,
+		Path:     c.Request().URL.Path,
+		RawQuery: query.Encode(),
+	}
+	return u.String()
+}
+
+// A page of keyset pagination. NextCursor is the id to pass as ?after= for
+// the next page, or null on the last one.
+type CursorPage struct {
+	XMLName    xml.Name  `json:"-" xml:"books"`
+	Data       []BookDTO `json:"data" xml:"data>BookDTO"`
+	NextCursor *string   `json:"nextCursor" xml:"nextCursor,omitempty"`
+}
+
+// Reads ?after= for keyset pagination. Unlike ?offset=, which makes the
+// database skip over all earlier books, this continues right after the
+// given id, so deep pages are as fast as the first one. An empty ?after=
+// starts at the beginning. The second value reports whether it was sent.
+func cursorFromQuery(c echo.Context) (*primitive.ObjectID, bool, error) {
+	if !c.QueryParams().Has("after") {
+		return nil, false, nil
+	}
+	if c.QueryParam("offset") != "" || c.QueryParam("sort") != "" {
+		return nil, true, fmt.Errorf("after cannot be combined with offset or sort")
+	}
+	raw := c.QueryParam("after")
+	if raw == "" {
+		return nil, true, nil
+	}
+	after, err := primitive.ObjectIDFromHex(raw)
+	if err != nil {
+		return nil, true, fmt.Errorf("after must be a book id")
+	}
+	return &after, true, nil
+}
+
+// Finds the page of books following the cursor, in _id order. One book more
+// than the limit is asked for, to know whether another page follows.
+func findCursorPage(coll *mongo.Collection, filter bson.M, after *primitive.ObjectID, limit int) (CursorPage, error) {
+	if after != nil {
+		filter["_id"] = bson.M{"$gt": *after}
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(limit + 1))
+	books, err := findBookDTOs(coll, filter, opts)
+	if err != nil {
+		return CursorPage{}, err
+	}
+	page := CursorPage{Data: books}
+	if len(books) > limit {
+		page.Data = books[:limit]
+		next := page.Data[limit-1].Id
+		page.NextCursor = &next
+	}
+	return page, nil
+}
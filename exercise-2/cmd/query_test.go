@@ -0,0 +1,463 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestFirstPageLinks(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		books := seedBooks()
+
+		mt.AddMockResponses(countReply(t, 3), booksReply(t, books[0], books[1]))
+		rec := serve(e, http.MethodGet, "/api/books?limit=2", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+		if !strings.Contains(rec.Body.String(), `"prev":null`) {
+			t.Errorf("body %s, want a null prev link", rec.Body.String())
+		}
+		var paged PagedBooks
+		if err := json.Unmarshal(rec.Body.Bytes(), &paged); err != nil {
+			t.Fatal(err)
+		}
+		if paged.Total != 3 || len(paged.Data) != 2 || paged.Links.Next == nil {
+			t.Fatalf("got %+v, want 2 of 3 books and a next link", paged)
+		}
+		next, err := url.Parse(*paged.Links.Next)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if next.Path != "/api/books" || next.Query().Get("offset") != "2" || next.Query().Get("limit") != "2" {
+			t.Errorf("next = %s, want /api/books with offset=2 and limit=2", next)
+		}
+	})
+}
+
+func TestSearchByName(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		books := seedBooks()
+
+		mt.AddMockResponses(booksReply(t, books[2]))
+		rec := serve(e, http.MethodGet, "/api/books?name=cat", "")
+		var got []BookDTO
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("body %s: %v", rec.Body.String(), err)
+		}
+		if len(got) != 1 || got[0].Name != "The Black Cat" {
+			t.Errorf("got %+v, want The Black Cat", got)
+		}
+
+		// The pattern sent has to match the name the way MongoDB would,
+		// ignoring case, and only that of the seed books
+		pattern, options := sentCommands(mt, "find")[0].Command.Lookup("filter", "bookname").Regex()
+		if options != "i" {
+			t.Errorf("regex options = %q, want i", options)
+		}
+		re := regexp.MustCompile("(?i)" + pattern)
+		for _, book := range books {
+			if matched := re.MatchString(book.BookName); matched != (book.BookName == "The Black Cat") {
+				t.Errorf("pattern %q matches %q: %v", pattern, book.BookName, matched)
+			}
+		}
+	})
+}
+
+func TestSearchByNameIsLiteral(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+
+		mt.AddMockResponses(booksReply(t))
+		serve(e, http.MethodGet, "/api/books?name=.*", "")
+		if pattern, _ := sentCommands(mt, "find")[0].Command.Lookup("filter", "bookname").Regex(); pattern != `\.\*` {
+			t.Errorf("pattern = %q, want the input escaped", pattern)
+		}
+	})
+}
+
+func TestBooksByISBNs(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		books := seedBooks()
+
+		mt.AddMockResponses(booksReply(t, books[0], books[1]))
+		rec := serve(e, http.MethodGet, "/api/books?isbns=958-30-0804-4,%209783649646099,958-30-0804-4", "")
+		var got []BookDTO
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil || len(got) != 2 {
+			t.Fatalf("got %s, want two books", rec.Body.String())
+		}
+
+		values, err := sentCommands(mt, "find")[0].Command.Lookup("filter", "bookisbn_digits", "$in").Array().Values()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var isbns []string
+		for _, value := range values {
+			isbns = append(isbns, value.StringValue())
+		}
+		if want := []string{"9583008044", "9783649646099"}; !slices.Equal(isbns, want) {
+			t.Errorf("looked up %q, want %q", isbns, want)
+		}
+	})
+}
+
+func TestConfiguredPageSizes(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		repo := testRepo(mt)
+		repo.PageSizes = PageSizes{Default: 2, Max: 5}
+		e := newTestServer(repo)
+
+		for i, test := range []struct {
+			query string
+			limit int64
+		}{
+			{"offset=0", 2},
+			{"limit=50", 5},
+		} {
+			mt.AddMockResponses(countReply(t, 10), booksReply(t))
+			if rec := serve(e, http.MethodGet, "/api/books?"+test.query, ""); rec.Code != http.StatusOK {
+				t.Fatalf("%s: status = %d, want 200: %s", test.query, rec.Code, rec.Body.String())
+			}
+			find := sentCommands(mt, "find")[i].Command
+			if limit, ok := find.Lookup("limit").AsInt64OK(); !ok || limit != test.limit {
+				t.Errorf("%s: find %s, want a limit of %d", test.query, find, test.limit)
+			}
+		}
+	})
+}
+
+// Evaluates the {$or: [{field: {$in: [...]}}, ...]} filters of
+// /api/books/incomplete against a stored book. A missing field counts as
+// null, like in the database.
+func matchesIncomplete(t *testing.T, filter bson.Raw, book bson.Raw) bool {
+	t.Helper()
+	conditions, err := filter.Lookup("$or").Array().Values()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, condition := range conditions {
+		elems, err := condition.Document().Elements()
+		if err != nil || len(elems) != 1 {
+			t.Fatalf("unexpected condition %s", condition)
+		}
+		value, err := book.LookupErr(elems[0].Key())
+		found := err == nil
+		wanted, err := elems[0].Value().Document().Lookup("$in").Array().Values()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range wanted {
+			switch {
+			case want.Type == bson.TypeNull:
+				if !found || value.Type == bson.TypeNull {
+					return true
+				}
+			case !found:
+			case want.IsNumber() && value.IsNumber():
+				if want.AsInt64() == value.AsInt64() {
+					return true
+				}
+			case want.Equal(value):
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestIncompleteBooks(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		complete := seedBooks()[1]
+		incomplete := seedBooks()[2]
+		incomplete.BookISBN, incomplete.BookISBNDigits, incomplete.BookPages = "", "", 0
+
+		mt.AddMockResponses(booksReply(t, incomplete))
+		rec := serve(e, http.MethodGet, "/api/books/incomplete", "")
+		var got []BookDTO
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("body %s: %v", rec.Body.String(), err)
+		}
+		if len(got) != 1 || got[0].Name != "The Black Cat" {
+			t.Errorf("got %+v, want The Black Cat only", got)
+		}
+
+		filter := sentCommands(mt, "find")[0].Command.Lookup("filter").Document()
+		storedIncomplete, err := bson.Marshal(incomplete)
+		if err != nil {
+			t.Fatal(err)
+		}
+		storedComplete, err := bson.Marshal(complete)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !matchesIncomplete(t, filter, storedIncomplete) {
+			t.Errorf("the filter %s misses the book without ISBN and pages", filter)
+		}
+		if matchesIncomplete(t, filter, storedComplete) {
+			t.Errorf("the filter %s matches a complete book", filter)
+		}
+	})
+}
+
+func TestWalkPagesWithCursors(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		books := seedBooks()
+
+		var names []string
+		after := ""
+		for pages := 1; ; pages++ {
+			if pages > len(books) {
+				t.Fatalf("still paging after %d pages", pages)
+			}
+			// What the database returns: the books following the cursor in
+			// _id order, up to the limit it was sent
+			rest := books
+			for len(rest) > 0 && rest[0].ID.Hex() <= after {
+				rest = rest[1:]
+			}
+			mt.AddMockResponses(booksReply(t, rest[:min(len(rest), 3)]...))
+
+			rec := serve(e, http.MethodGet, "/api/books?limit=2&after="+after, "")
+			var page CursorPage
+			if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+				t.Fatalf("body %s: %v", rec.Body.String(), err)
+			}
+			for _, book := range page.Data {
+				names = append(names, book.Name)
+			}
+
+			find := sentCommands(mt, "find")[pages-1].Command
+			if limit := find.Lookup("limit").AsInt64(); limit != 3 {
+				t.Errorf("page %d asked for %d books, want 3", pages, limit)
+			}
+			if gt, err := find.LookupErr("filter", "_id", "$gt"); after != "" && (err != nil || gt.ObjectID().Hex() != after) {
+				t.Errorf("page %d does not continue after %s: %s", pages, after, find)
+			}
+			if page.NextCursor == nil {
+				break
+			}
+			after = *page.NextCursor
+		}
+
+		if want := []string{"The Vortex", "Frankenstein", "The Black Cat"}; !slices.Equal(names, want) {
+			t.Errorf("walked through %v, want %v", names, want)
+		}
+	})
+}
+
+func TestSortAuthorsWithCollation(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		t.Setenv("COLLATION_LOCALE", "es")
+		collation, err := collationFromEnv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		repo := testRepo(mt)
+		repo.Collation = collation
+		e := newTestServer(repo)
+		books := seedBooks()
+		conrad := BookStore{ID: primitive.NewObjectID(), BookName: "Nostromo", BookAuthor: "Joseph Conrad"}
+
+		// In the byte order of MongoDB "José" would come after "Joseph",
+		// under a locale it comes first
+		mt.AddMockResponses(booksReply(t, books[2], books[0], conrad, books[1]))
+		rec := serve(e, http.MethodGet, "/api/books?sort=author", "")
+		var got []BookDTO
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("body %s: %v", rec.Body.String(), err)
+		}
+		var authors []string
+		for _, book := range got {
+			authors = append(authors, book.Author)
+		}
+		want := []string{"Edgar Allan Poe", "José Eustasio Rivera", "Joseph Conrad", "Mary Shelley"}
+		if !slices.Equal(authors, want) {
+			t.Errorf("authors = %q, want %q", authors, want)
+		}
+
+		find := sentCommands(mt, "find")[0].Command
+		if locale := find.Lookup("collation", "locale").StringValue(); locale != "es" {
+			t.Errorf("sorted with locale %q, want es", locale)
+		}
+		if _, err := find.LookupErr("sort", "bookauthor"); err != nil {
+			t.Errorf("find %s is not sorted by author", find)
+		}
+	})
+}
+
+func TestTextQueryWithMinYear(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+
+		mt.AddMockResponses(booksReply(t, seedBooks()[2]))
+		rec := serve(e, http.MethodGet, "/api/books/query?q=cat&minYear=1840", "")
+		var got []BookDTO
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("body %s: %v", rec.Body.String(), err)
+		}
+		if len(got) != 1 || got[0].Name != "The Black Cat" {
+			t.Errorf("got %+v, want The Black Cat", got)
+		}
+
+		find := sentCommands(mt, "find")[0].Command
+		conditions := find.Lookup("filter", "$and").Array()
+		if search, err := conditions.Index(0).Value().Document().LookupErr("$text", "$search"); err != nil || search.StringValue() != "cat" {
+			t.Errorf("filter %s does not search for cat", conditions)
+		}
+		if year, err := conditions.Index(1).Value().Document().LookupErr("bookyear", "$gte"); err != nil || year.AsInt64() != 1840 {
+			t.Errorf("filter %s does not start at 1840", conditions)
+		}
+		if _, err := find.LookupErr("sort", "score", "$meta"); err != nil {
+			t.Errorf("find %s is not sorted by relevance", find)
+		}
+	})
+}
+
+func TestRecentNewestFirst(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+
+		mt.AddMockResponses(booksReply(t), writeReply(1))
+		rec := serve(e, http.MethodPost, "/api/books", `{"name":"Dracula","author":"Bram Stoker","isbn":"978-0-14-143984-6","pages":418,"year":1897}`)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("status = %d, want 201: %s", rec.Code, rec.Body.String())
+		}
+		var dracula BookStore
+		if err := bson.Unmarshal(insertedDoc(t, mt, 0), &dracula); err != nil {
+			t.Fatal(err)
+		}
+
+		// Dracula was added last, so the database puts it first
+		seeded := seedBooks()
+		if !dracula.CreatedAt.After(seeded[2].CreatedAt) {
+			t.Fatalf("Dracula was added at %v, not after the seed books", dracula.CreatedAt)
+		}
+		mt.AddMockResponses(booksReply(t, dracula, seeded[2]))
+		rec = serve(e, http.MethodGet, "/api/books/recent?limit=2", "")
+		var got []BookDTO
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("body %s: %v", rec.Body.String(), err)
+		}
+		if len(got) != 2 || got[0].Name != "Dracula" {
+			t.Errorf("got %+v, want Dracula first", got)
+		}
+
+		find := sentCommands(mt, "find")[1].Command
+		sort := find.Lookup("sort").Document()
+		keys, err := sort.Elements()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) != 2 || keys[0].Key() != "createdat" || keys[0].Value().AsInt64() != -1 || keys[1].Key() != "_id" || keys[1].Value().AsInt64() != -1 {
+			t.Errorf("sorted by %s, want createdat then _id, newest first", sort)
+		}
+		if limit := find.Lookup("limit").AsInt64(); limit != 2 {
+			t.Errorf("limit = %d, want 2", limit)
+		}
+	})
+}
+
+func TestListingTruncatedAtMaxResults(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		repo := testRepo(mt)
+		repo.MaxResults = 2
+		e := newTestServer(repo)
+		books := seedBooks()
+
+		// One more than the cap is asked for, to know whether there are more
+		mt.AddMockResponses(booksReply(t, books...))
+		rec := serve(e, http.MethodGet, "/api/books", "")
+		var got []BookDTO
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("body %s: %v", rec.Body.String(), err)
+		}
+		if len(got) != 2 || rec.Header().Get(headerResultTruncated) != "true" {
+			t.Errorf("got %d books, %s %q, want 2 and the truncation header", len(got), headerResultTruncated, rec.Header().Get(headerResultTruncated))
+		}
+		if limit := sentCommands(mt, "find")[0].Command.Lookup("limit").AsInt64(); limit != 3 {
+			t.Errorf("limit = %d, want 3", limit)
+		}
+
+		// Exactly as many as the cap is not cut off
+		mt.AddMockResponses(booksReply(t, books[:2]...))
+		rec = serve(e, http.MethodGet, "/api/books", "")
+		if truncated := rec.Header().Get(headerResultTruncated); truncated != "" {
+			t.Errorf("%s = %q for a complete listing", headerResultTruncated, truncated)
+		}
+	})
+}
+
+func TestBookWithNameOnly(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		frankenstein := seedBooks()[1]
+
+		// Even a full book coming back is cut down to the requested fields
+		mt.AddMockResponses(booksReply(t, frankenstein))
+		rec := serve(e, http.MethodGet, "/api/books/"+frankenstein.ID.Hex()+"?fields=name", "")
+		var got map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("body %s: %v", rec.Body.String(), err)
+		}
+		want := map[string]interface{}{"id": frankenstein.ID.Hex(), "name": "Frankenstein"}
+		if len(got) != len(want) || got["id"] != want["id"] || got["name"] != want["name"] {
+			t.Errorf("got %v, want %v", got, want)
+		}
+
+		projection := sentCommands(mt, "find")[0].Command.Lookup("projection").Document()
+		if elems, err := projection.Elements(); err != nil || len(elems) != 1 || elems[0].Key() != "bookname" {
+			t.Errorf("projection %s, want the name only", projection)
+		}
+	})
+}
+
+// The page lists the books in the order the database sends them, which is
+// the order asked for with ?sort=
+func TestBooksPageSortedByYear(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		books := seedBooks()
+
+		mt.AddMockResponses(booksReply(t, books[1], books[2], books[0]))
+		rec := serve(e, http.MethodGet, "/books?sort=year", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+		body := rec.Body.String()
+		frankenstein := strings.Index(body, "Frankenstein")
+		blackCat := strings.Index(body, "The Black Cat")
+		vortex := strings.Index(body, "The Vortex")
+		if frankenstein < 0 || !(frankenstein < blackCat && blackCat < vortex) {
+			t.Errorf("the rows are not ordered 1818, 1843, 1924:\n%s", body)
+		}
+		sort, err := sentCommands(mt, "find")[0].Command.Lookup("sort").Document().Elements()
+		if err != nil || len(sort) != 2 || sort[0].Key() != "bookyear" || sort[0].Value().AsInt64() != 1 || sort[1].Key() != "_id" {
+			t.Errorf("sorted by %v (%v), want bookyear then _id, ascending", sort, err)
+		}
+
+		// An unknown column is not an error, the books come in their usual
+		// order
+		mt.ClearEvents()
+		mt.AddMockResponses(booksReply(t, books...))
+		rec = serve(e, http.MethodGet, "/books?sort=color", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+		if _, err := sentCommands(mt, "find")[0].Command.LookupErr("sort"); err == nil {
+			t.Errorf("find %s is sorted, want the default order", sentCommands(mt, "find")[0].Command)
+		}
+	})
+}
@@ -0,0 +1,1468 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Everything the handlers need to reach the database, handed over from main
+type BookRepository struct {
+	// Used for inserts, updates and deletes
+	Books *mongo.Collection
+	// Used by the listing endpoints, possibly reading from a secondary
+	// (see READ_PREFERENCE)
+	ReadBooks       *mongo.Collection
+	History         *mongo.Collection
+	IdempotencyKeys *mongo.Collection
+	AuthorStats     *AuthorStatsCache
+	Limits          BookLimits
+	PageSizes       PageSizes
+	// Set when STRICT_VALIDATION is on
+	BookSchema *JSONSchema
+	// Used to sort names, see COLLATION_LOCALE
+	Collation *options.Collation
+	// Cap for listings without pagination, see capResults
+	MaxResults int
+	// Token for the /api/admin endpoints, which are left out without one
+	AdminToken string
+	// Set through ALLOW_RESET, see POST /api/admin/reset
+	AllowReset        bool
+	CollectionOptions CollectionOptions
+	// Placeholder for imports with ?defaults=true
+	ImportDefaultAuthor string
+}
+
+// Registers all pages and API endpoints on the given server and returns it.
+// Keeping them out of main lets us build the whole route table without
+// starting the server.
+func registerRoutes(e *echo.Echo, repo BookRepository) *echo.Echo {
+	coll := repo.Books
+	readColl := repo.ReadBooks
+	history := repo.History
+	idempotencyKeys := repo.IdempotencyKeys
+	authorStats := repo.AuthorStats
+	limits := repo.Limits
+	pageSizes := repo.PageSizes
+	collation := repo.Collation
+	maxResults := repo.MaxResults
+
+	// With a schema, book payloads are checked against it before binding
+	var bookBody []echo.MiddlewareFunc
+	if repo.BookSchema != nil {
+		bookBody = append(bookBody, strictBody(repo.BookSchema))
+	}
+
+	// Endpoint definition. Here, we divided into two groups: top-level routes
+	// starting with /, which usually serve webpages. For our RESTful endpoints,
+	// we prefix the route with /api to indicate more information or resources
+	// are available under such route.
+	e.GET("/", func(c echo.Context) error {
+		return c.Render(200, "index", nil)
+	})
+
+	// The table is sorted like GET /api/books with ?sort= and ?order=,
+	// which the links in its header set. Unlike the API, an unknown sort
+	// shows the books in their usual order rather than an error.
+	e.GET("/books", func(c echo.Context) error {
+		table := BookTable{Sort: c.QueryParam("sort"), Order: c.QueryParam("order")}
+		opts, err := sortOptionsFromQuery(c, collation)
+		if err != nil {
+			opts = options.Find()
+			table.Sort, table.Order = "", ""
+		}
+		books, err := findAllBooks(readColl, opts)
+		if err != nil {
+			return c.Render(http.StatusInternalServerError, "not-found", "The books could not be loaded, please try again.")
+		}
+		table.Flash = popFlash(c)
+		table.Books = books
+		return c.Render(200, "book-table", table)
+	})
+
+	// Detail page of a single book. Unknown or malformed ids get a friendly
+	// 404 page instead of the JSON error the API would answer with.
+	e.GET("/book/:id", func(c echo.Context) error {
+		objId, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			return c.Render(http.StatusNotFound, "not-found", "This book does not exist.")
+		}
+		var book BookStore
+		err = readColl.FindOne(context.TODO(), bson.M{"_id": objId}).Decode(&book)
+		if err == mongo.ErrNoDocuments {
+			return c.Render(http.StatusNotFound, "not-found", "This book does not exist.")
+		}
+		if err != nil {
+			return c.Render(http.StatusInternalServerError, "not-found", "The book could not be loaded, please try again.")
+		}
+		return c.Render(http.StatusOK, "book-detail", book)
+	})
+
+	e.GET("/authors", func(c echo.Context) error {
+		author, err := distinctAuthors(readColl, collation)
+		if err != nil {
+			return err
+		}
+		return c.Render(200, "authors-table", author)
+	})
+
+	e.GET("/years", func(c echo.Context) error {
+		years, err := distinctYears(readColl)
+		if err != nil {
+			return err
+		}
+		return c.Render(200, "year-table", years)
+	})
+
+	e.GET("/search", func(c echo.Context) error {
+		return c.Render(200, "search-bar", nil)
+	})
+
+	// HTML form to add a book without crafting JSON by hand
+	e.GET("/create", func(c echo.Context) error {
+		return c.Render(http.StatusOK, "create-form", CreateForm{})
+	})
+
+	// Handles the form above. Invalid input renders the form again with the
+	// errors inline (422, which htmx is told to swap in index.html), a valid
+	// book is stored and the browser is sent back to the list.
+	e.POST("/create", func(c echo.Context) error {
+		book, form := bookFromForm(c, limits)
+		if len(form.Errors) > 0 {
+			return c.Render(http.StatusUnprocessableEntity, "create-form", form)
+		}
+
+		bookStore := book.ToStore()
+		bookStore.CreatedAt = time.Now().UTC()
+		bookStore.UpdatedAt = bookStore.CreatedAt
+		_, err := coll.InsertOne(context.TODO(), bookStore)
+		if mongo.IsDuplicateKeyError(err) {
+			// The unique ISBN index, see bookIndexes
+			form.Errors["isbn"] = "is already used by another book"
+			return c.Render(http.StatusUnprocessableEntity, "create-form", form)
+		}
+		if err != nil {
+			form.Errors["form"] = "the book could not be saved, please try again"
+			return c.Render(http.StatusInternalServerError, "create-form", form)
+		}
+		setFlash(c, "Book added")
+		return c.Redirect(http.StatusSeeOther, "/books")
+	})
+
+	// Delete button of the detail page. Browsers cannot send DELETE from a
+	// plain form, hence the POST.
+	e.POST("/book/:id/delete", func(c echo.Context) error {
+		objId, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			return c.Render(http.StatusNotFound, "not-found", "This book does not exist.")
+		}
+		var deleted BookStore
+		err = coll.FindOneAndDelete(context.TODO(), bson.M{"_id": objId}).Decode(&deleted)
+		if err == mongo.ErrNoDocuments {
+			return c.Render(http.StatusNotFound, "not-found", "This book does not exist.")
+		}
+		if err != nil {
+			return c.Render(http.StatusInternalServerError, "not-found", "The book could not be deleted, please try again.")
+		}
+		recordHistory(history, historyDelete, deleted)
+		setFlash(c, "Book deleted")
+		return c.Redirect(http.StatusSeeOther, "/books")
+	})
+
+	// Lists all books, optionally filtered (see bookFilterFromQuery) and
+	// sorted with ?sort=createdAt&order=desc. Answers in JSON unless the
+	// Accept header asks for XML.
+	// With ?offset= and/or ?limit= the books are paginated and wrapped in an
+	// envelope holding the total and links to the next and previous pages.
+	// ?after=<id> pages through the books in insertion (_id) order instead,
+	// returning the cursor for the next page.
+	// HEAD answers with the same headers, but without the body.
+	e.Match([]string{http.MethodGet, http.MethodHead}, "/api/books", func(c echo.Context) error {
+		format, ok := negotiateFormat(c)
+		if !ok {
+			return respondError(c, http.StatusNotAcceptable, codeNotAcceptable, "only application/json and application/xml are supported")
+		}
+		opts, err := sortOptionsFromQuery(c, collation)
+		if err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidQuery, err.Error())
+		}
+		page, paginated, err := pageFromQuery(c, pageSizes)
+		if err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidQuery, err.Error())
+		}
+		filter, err := bookFilterFromQuery(c)
+		if err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidQuery, err.Error())
+		}
+
+		// Keyset pagination with ?after=<id>&limit=
+		after, keyset, err := cursorFromQuery(c)
+		if err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidQuery, err.Error())
+		}
+		if keyset {
+			if !paginated {
+				page.Limit = pageSizes.Default
+			}
+			cursorPage, err := findCursorPage(readColl, filter, after, page.Limit)
+			if err != nil {
+				return respondError(c, http.StatusInternalServerError, codeInternal, "error in finding the books")
+			}
+			if format == formatXML {
+				return c.XML(http.StatusOK, cursorPage)
+			}
+			return c.JSON(http.StatusOK, cursorPage)
+		}
+
+		if !paginated {
+			// One more than the cap, to know whether there were more
+			if maxResults > 0 {
+				opts.SetLimit(int64(maxResults + 1))
+			}
+			payload, err := findBookDTOs(readColl, filter, opts)
+			if err != nil {
+				return respondError(c, http.StatusInternalServerError, codeInternal, "error in finding the books")
+			}
+			payload = capResults(c, payload, maxResults)
+			if format == formatXML {
+				return c.XML(http.StatusOK, BookListXML{Books: payload})
+			}
+			return c.JSON(http.StatusOK, payload)
+		}
+
+		countDone := timeQuery(readColl, "count", filter)
+		total, err := readColl.CountDocuments(context.TODO(), filter)
+		countDone()
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, codeInternal, "error in counting the books")
+		}
+		// Pages are only stable with a defined order, so fall back to _id
+		if opts.Sort == nil {
+			opts.SetSort(bson.D{{Key: "_id", Value: 1}})
+		}
+		opts.SetSkip(int64(page.Offset)).SetLimit(int64(page.Limit))
+		payload, err := findBookDTOs(readColl, filter, opts)
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, codeInternal, "error in finding the books")
+		}
+		paged := PagedBooks{
+			Data:  payload,
+			Total: total,
+			Links: pageLinks(c, page, total),
+		}
+		if format == formatXML {
+			return c.XML(http.StatusOK, paged)
+		}
+		return c.JSON(http.StatusOK, paged)
+	}, headWithoutBody())
+
+	// Finds a book by its ISBN, with or without hyphens, e.g.
+	// /api/books/by-isbn/9783649646099 finds "978-3-649-64609-9"
+	e.GET("/api/books/by-isbn/:isbn", func(c echo.Context) error {
+		isbn, err := url.PathUnescape(c.Param("isbn"))
+		if err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidQuery, "invalid isbn")
+		}
+		isbn = strings.TrimSpace(isbn)
+		if !isValidISBN(isbn) {
+			return respondError(c, http.StatusBadRequest, codeInvalidQuery, "isbn is not a valid ISBN-10 or ISBN-13")
+		}
+		// The unique index allows one book per ISBN, but it cannot be built
+		// while older data breaks the rule; asking for two finds that
+		books, err := findBookDTOs(readColl, isbnFilter(isbn), options.Find().SetLimit(2))
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, codeInternal, "error in finding the book")
+		}
+		switch len(books) {
+		case 0:
+			return respondError(c, http.StatusNotFound, codeNotFound, "book does not exist")
+		case 1:
+			return c.JSON(http.StatusOK, books[0])
+		}
+		return respondError(c, http.StatusConflict, codeConflict, "more than one book has this isbn")
+	})
+
+	// New arrivals: ?limit=N (default 10, at most MAX_PAGE_SIZE) books, the
+	// most recently added first. Books added at the same time, or before
+	// createdat was tracked, are ordered by their id, which grows over time.
+	e.GET("/api/books/recent", func(c echo.Context) error {
+		limit := 10
+		if raw := c.QueryParam("limit"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n <= 0 {
+				return respondError(c, http.StatusBadRequest, codeInvalidQuery, "limit must be a positive number")
+			}
+			limit = min(n, pageSizes.Max)
+		}
+		opts := options.Find().
+			SetSort(bson.D{{Key: "createdat", Value: -1}, {Key: "_id", Value: -1}}).
+			SetLimit(int64(limit))
+		books, err := findBookDTOs(readColl, bson.M{}, opts)
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, codeInternal, "error in finding the books")
+		}
+		return c.JSON(http.StatusOK, books)
+	})
+
+	// Full text search combined with filters, best matches first, e.g.
+	// /api/books/query?q=cat&minYear=1800 (see textQueryFromQuery)
+	e.GET("/api/books/query", func(c echo.Context) error {
+		filter, err := textQueryFromQuery(c)
+		if err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidQuery, err.Error())
+		}
+		page, paged, err := pageFromQuery(c, pageSizes)
+		if err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidQuery, err.Error())
+		}
+		if !paged {
+			page.Limit = pageSizes.Default
+		}
+		// The relevance computed by $text is only available through $meta
+		score := bson.M{"$meta": "textScore"}
+		opts := options.Find().
+			SetProjection(bson.M{"score": score}).
+			SetSort(bson.D{{Key: "score", Value: score}, {Key: "_id", Value: 1}}).
+			SetSkip(int64(page.Offset)).
+			SetLimit(int64(page.Limit))
+		books, err := findBookDTOs(readColl, filter, opts)
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, codeInternal, "error in searching the books")
+		}
+		return c.JSON(http.StatusOK, books)
+	})
+
+	// Returns ?count=N (default 1, at most 20) random books using $sample.
+	// When the collection holds fewer books than requested, all of them are
+	// returned.
+	e.GET("/api/books/random", func(c echo.Context) error {
+		count := 1
+		if raw := c.QueryParam("count"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n <= 0 {
+				return respondError(c, http.StatusBadRequest, codeInvalidQuery, "count must be a positive number")
+			}
+			count = min(n, 20)
+		}
+
+		pipeline := mongo.Pipeline{{{Key: "$sample", Value: bson.M{"size": count}}}}
+		cursor, err := readColl.Aggregate(context.TODO(), pipeline)
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, codeInternal, "error in sampling books")
+		}
+		var results []BookStore
+		if err = cursor.All(context.TODO(), &results); err != nil {
+			return respondError(c, http.StatusInternalServerError, codeInternal, "error in sampling books")
+		}
+
+		// $sample may hand out the same document twice, so drop repeats
+		seen := map[primitive.ObjectID]bool{}
+		payload := make([]BookDTO, 0, len(results))
+		for _, res := range results {
+			if seen[res.ID] {
+				continue
+			}
+			seen[res.ID] = true
+			payload = append(payload, res.ToDTO())
+		}
+		return c.JSON(http.StatusOK, payload)
+	})
+
+	// The books as a CSV download, taking the same filters and sorting as
+	// GET /api/books plus ?delimiter= (, ; | or tab)
+	e.GET("/api/books/export.csv", func(c echo.Context) error {
+		delimiter, err := csvDelimiterFromQuery(c)
+		if err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidQuery, err.Error())
+		}
+		opts, err := sortOptionsFromQuery(c, collation)
+		if err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidQuery, err.Error())
+		}
+		filter, err := bookFilterFromQuery(c)
+		if err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidQuery, err.Error())
+		}
+		books, err := findBookDTOs(readColl, filter, opts)
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, codeInternal, "error in finding the books")
+		}
+
+		c.Response().Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+		c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="books.csv"`)
+		c.Response().WriteHeader(http.StatusOK)
+		return writeBooksCSV(c.Response(), books, delimiter)
+	})
+
+	// Backup of the whole collection as a JSON array, which POST
+	// /api/books/restore reads back. ?pretty=true indents it.
+	e.GET("/api/books/export.json", func(c echo.Context) error {
+		pretty := c.QueryParam("pretty") == "true"
+		c.Response().Header().Set(echo.HeaderContentType, echo.MIMEApplicationJSONCharsetUTF8)
+		c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="books.json"`)
+		c.Response().WriteHeader(http.StatusOK)
+		// Once streaming started the status cannot change anymore, so a
+		// failure halfway through leaves a truncated (invalid) file
+		if err := streamBooksJSON(c.Response(), readColl, pretty); err != nil {
+			log.Printf("error: export of the books failed: %v\n", err)
+		}
+		return nil
+	})
+
+	// Books without an ISBN, pages or a year, for cleaning up the catalog.
+	// ?field=isbn (or pages, year) narrows it down to one of them.
+	e.GET("/api/books/incomplete", func(c echo.Context) error {
+		filter, err := incompleteFilter(c.QueryParam("field"))
+		if err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidQuery, err.Error())
+		}
+		payload, err := findBookDTOs(readColl, filter)
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, codeInternal, "error in finding the books")
+		}
+		return c.JSON(http.StatusOK, capResults(c, payload, maxResults))
+	})
+
+	// A single book. ?fields=name,author returns only those fields (and the
+	// id), loading nothing else from the database. HEAD tells whether the
+	// book exists and how large it is, without sending it.
+	e.Match([]string{http.MethodGet, http.MethodHead}, "/api/books/:id", func(c echo.Context) error {
+		objId, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidID, "invalid id")
+		}
+		projection, fields, err := fieldsFromQuery(c)
+		if err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidQuery, err.Error())
+		}
+		opts := options.FindOne()
+		if projection != nil {
+			opts.SetProjection(projection)
+		}
+		var book BookStore
+		err = readColl.FindOne(context.TODO(), bson.M{"_id": objId}, opts).Decode(&book)
+		if err == mongo.ErrNoDocuments {
+			return respondError(c, http.StatusNotFound, codeNotFound, "book does not exist")
+		}
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, codeInternal, "error in finding the book")
+		}
+		if fields == nil {
+			return c.JSON(http.StatusOK, book.ToDTO())
+		}
+		picked, err := pickFields(book.ToDTO(), fields)
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, codeInternal, "error in encoding the book")
+		}
+		return c.JSON(http.StatusOK, picked)
+	}, headWithoutBody())
+
+	// Simple recommendations: up to 5 other books by the same author or
+	// published within 10 years of the given one
+	e.GET("/api/books/:id/similar", func(c echo.Context) error {
+		objId, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidID, "invalid id")
+		}
+		var book BookStore
+		err = readColl.FindOne(context.TODO(), bson.M{"_id": objId}).Decode(&book)
+		if err == mongo.ErrNoDocuments {
+			return respondError(c, http.StatusNotFound, codeNotFound, "book does not exist")
+		}
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, codeInternal, "error in finding the book")
+		}
+
+		criteria := bson.A{bson.M{"bookauthor": book.BookAuthor}}
+		if book.BookYear != 0 {
+			criteria = append(criteria, bson.M{"bookyear": bson.M{"$gte": book.BookYear - 10, "$lte": book.BookYear + 10}})
+		}
+		filter := bson.M{
+			"_id": bson.M{"$ne": objId},
+			"$or": criteria,
+		}
+		books, err := findBookDTOs(readColl, filter, options.Find().SetLimit(5))
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, codeInternal, "error in finding similar books")
+		}
+		return c.JSON(http.StatusOK, books)
+	})
+
+	// Timeline navigation: the books published in the years before or after
+	// the given book, the closest first. Books without a year are left out.
+	bookNeighbours := func(operator string, direction int) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			objId, err := primitive.ObjectIDFromHex(c.Param("id"))
+			if err != nil {
+				return respondError(c, http.StatusBadRequest, codeInvalidID, "invalid id")
+			}
+			page, paged, err := pageFromQuery(c, pageSizes)
+			if err != nil {
+				return respondError(c, http.StatusBadRequest, codeInvalidQuery, err.Error())
+			}
+			if !paged {
+				page.Limit = pageSizes.Default
+			}
+
+			var book BookStore
+			err = readColl.FindOne(context.TODO(), bson.M{"_id": objId}).Decode(&book)
+			if err == mongo.ErrNoDocuments {
+				return respondError(c, http.StatusNotFound, codeNotFound, "book does not exist")
+			}
+			if err != nil {
+				return respondError(c, http.StatusInternalServerError, codeInternal, "error in finding the book")
+			}
+			if book.BookYear == 0 {
+				return c.JSON(http.StatusOK, []BookDTO{})
+			}
+
+			years := bson.M{operator: book.BookYear}
+			if operator == "$lt" {
+				// 0 stands for an unknown year, not for the earliest one
+				years["$gt"] = 0
+			}
+			filter := bson.M{"bookyear": years}
+			opts := options.Find().
+				SetSort(bson.D{{Key: "bookyear", Value: direction}, {Key: "_id", Value: direction}}).
+				SetSkip(int64(page.Offset)).
+				SetLimit(int64(page.Limit))
+			books, err := findBookDTOs(readColl, filter, opts)
+			if err != nil {
+				return respondError(c, http.StatusInternalServerError, codeInternal, "error in finding the books")
+			}
+			return c.JSON(http.StatusOK, books)
+		}
+	}
+	e.GET("/api/books/:id/before", bookNeighbours("$lt", -1))
+	e.GET("/api/books/:id/after", bookNeighbours("$gt", 1))
+
+	// Name and version of the running build
+	e.GET("/api/ping", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, buildInfo())
+	})
+
+	// Probes in the style of Kubernetes. /livez only tells that the process
+	// is running and answers even without a database, so a database outage
+	// does not get us restarted. /readyz tells whether we can serve
+	// requests, see checkReadiness.
+	e.GET("/livez", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+	})
+	e.GET("/readyz", func(c echo.Context) error {
+		if err := checkReadiness(coll.Database().Client()); err != nil {
+			return respondError(c, http.StatusServiceUnavailable, codeNotReady, err.Error())
+		}
+		return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+	})
+
+	e.GET("/api/authors", func(c echo.Context) error {
+		authors, err := distinctAuthors(readColl, collation)
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, codeInternal, "error in finding the authors")
+		}
+		return c.JSON(http.StatusOK, capResults(c, authors, maxResults))
+	})
+
+	// Authors by the first letter of their surname, for an A-Z index, e.g.
+	// {"S": ["Mary Shelley"]} (see aggregateAuthorIndex)
+	e.GET("/api/authors/index", func(c echo.Context) error {
+		index, err := aggregateAuthorIndex(readColl, collation)
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, codeInternal, "error in building the author index")
+		}
+		return c.JSON(http.StatusOK, index)
+	})
+
+	// Renames an author across all of their books, e.g. to fix a typo:
+	// {"from": "Mary Shelly", "to": "Mary Shelley"}
+	e.PUT("/api/authors", func(c echo.Context) error {
+		rename := new(AuthorRenameDTO)
+		if err := c.Bind(rename); err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidPayload, "error in payload conversion")
+		}
+		from := normalizeText(rename.From)
+		to := normalizeText(rename.To)
+		if from == "" || to == "" {
+			return respondError(c, http.StatusBadRequest, codeInvalidPayload, "from and to must not be empty")
+		}
+		if from == to {
+			return respondError(c, http.StatusBadRequest, codeInvalidPayload, "from and to must be different")
+		}
+
+		result, err := coll.UpdateMany(
+			context.TODO(),
+			bson.M{"bookauthor": from},
+			bson.M{"$set": bson.M{"bookauthor": to, "bookauthor_lower": authorKey(to), "updatedat": time.Now().UTC()}},
+		)
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, codeInternal, "error in renaming the author")
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{"modified": result.ModifiedCount})
+	})
+
+	// Books of one author, e.g. /api/authors/Mary%20Shelley/books. The name
+	// has to match exactly, ignoring case, which the indexed bookauthor_lower
+	// takes care of.
+	e.GET("/api/authors/:author/books", func(c echo.Context) error {
+		author, err := url.PathUnescape(c.Param("author"))
+		if err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidQuery, "invalid author")
+		}
+		author = normalizeText(author)
+		if author == "" {
+			return respondError(c, http.StatusBadRequest, codeInvalidQuery, "author must not be empty")
+		}
+		payload, err := findBookDTOs(readColl, bson.M{"bookauthor_lower": authorKey(author)})
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, codeInternal, "error in finding the books")
+		}
+		return c.JSON(http.StatusOK, capResults(c, payload, maxResults))
+	})
+
+	e.GET("/api/years", func(c echo.Context) error {
+		years, err := distinctYears(readColl)
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, codeInternal, "error in finding the years")
+		}
+		return c.JSON(http.StatusOK, capResults(c, years, maxResults))
+	})
+
+	// Prior versions of a book, oldest first. Deleted books keep their
+	// history, so this does not check that the book still exists.
+	e.GET("/api/books/:id/history", func(c echo.Context) error {
+		objId, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidID, "invalid id")
+		}
+		entries, err := findHistory(history, objId)
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, codeInternal, "error in finding the history")
+		}
+		return c.JSON(http.StatusOK, entries)
+	})
+
+	e.GET("/api/stats/authors", func(c echo.Context) error {
+		stats, err := authorStats.Get()
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, codeInternal, "error in computing author statistics")
+		}
+		return c.JSON(http.StatusOK, capResults(c, stats, maxResults))
+	})
+
+	// Books added per day, or per month with ?interval=month
+	e.GET("/api/stats/timeline", func(c echo.Context) error {
+		interval := c.QueryParam("interval")
+		if interval == "" {
+			interval = "day"
+		}
+		format, ok := timelineIntervals[interval]
+		if !ok {
+			return respondError(c, http.StatusBadRequest, codeInvalidQuery, "interval must be day or month")
+		}
+		buckets, err := aggregateTimeline(readColl, format)
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, codeInternal, "error in computing the timeline")
+		}
+		return c.JSON(http.StatusOK, capResults(c, buckets, maxResults))
+	})
+
+	// Total, average, smallest and largest page count
+	e.GET("/api/stats/pages", func(c echo.Context) error {
+		stats, err := aggregatePageStats(readColl)
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, codeInternal, "error in computing page statistics")
+		}
+		return c.JSON(http.StatusOK, stats)
+	})
+
+	// Histogram of the page counts, see aggregatePageBuckets
+	e.GET("/api/stats/page-buckets", func(c echo.Context) error {
+		bounds, err := pageBoundsFromQuery(c)
+		if err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidQuery, err.Error())
+		}
+		buckets, err := aggregatePageBuckets(readColl, bounds)
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, codeInternal, "error in computing the page buckets")
+		}
+		return c.JSON(http.StatusOK, buckets)
+	})
+
+	// Number of books per publication year, e.g. [{"year":1818,"count":1}]
+	e.GET("/api/stats/by-year", func(c echo.Context) error {
+		counts, err := aggregateYearCounts(readColl)
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, codeInternal, "error in counting the books per year")
+		}
+		return c.JSON(http.StatusOK, counts)
+	})
+
+	// Runs the checks of POST /api/books on a payload without storing it,
+	// so forms can show the problems before the final submit
+	e.POST("/api/books/validate", func(c echo.Context) error {
+		book := new(PostBookDTO)
+		if err := c.Bind(book); err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidPayload, "error in payload conversion")
+		}
+		if err := c.Validate(book); err != nil {
+			return c.JSON(http.StatusBadRequest, ValidationResult{Valid: false, Errors: fieldErrors(err)})
+		}
+		if errs := validateBook(book.ToStore(), limits); errs != nil {
+			return c.JSON(http.StatusBadRequest, ValidationResult{Valid: false, Errors: errs})
+		}
+		return c.JSON(http.StatusOK, ValidationResult{Valid: true})
+	})
+
+	e.POST("/api/books", func(c echo.Context) error {
+		book := new(PostBookDTO)
+		err := c.Bind(book)
+		if err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidPayload, "error in payload conversion")
+		}
+		if err = c.Validate(book); err != nil {
+			return c.JSON(http.StatusBadRequest, ValidationResult{Valid: false, Errors: fieldErrors(err)})
+		}
+
+		// ?upsert=true creates the book or updates the one with the same
+		// ISBN, for clients syncing another catalog. Repeating it is
+		// harmless, so the Idempotency-Key is not needed here.
+		if raw := c.QueryParam("upsert"); raw != "" {
+			upsert, err := strconv.ParseBool(raw)
+			if err != nil {
+				return respondError(c, http.StatusBadRequest, codeInvalidQuery, "upsert must be true or false")
+			}
+			if upsert {
+				bookStore := book.ToStore()
+				errs := validateBook(bookStore, limits)
+				if bookStore.BookISBN == "" {
+					errs = append(errs, FieldError{Field: "isbn", Message: "is required for an upsert"})
+				}
+				if errs != nil {
+					return c.JSON(http.StatusBadRequest, ValidationResult{Valid: false, Errors: errs})
+				}
+				stored, previous, err := upsertBookByISBN(coll, bookStore)
+				if err != nil {
+					return respondError(c, http.StatusInternalServerError, codeInternal, "error in upserting the book")
+				}
+				if previous == nil {
+					return c.JSON(http.StatusCreated, UpsertResult{Created: true, Book: stored.ToDTO()})
+				}
+				recordHistory(history, historyUpdate, *previous)
+				return c.JSON(http.StatusOK, UpsertResult{Created: false, Book: stored.ToDTO()})
+			}
+		}
+
+		bookStore := book.ToStore()
+		if errs := validateBook(bookStore, limits); errs != nil {
+			return c.JSON(http.StatusBadRequest, ValidationResult{Valid: false, Errors: errs})
+		}
+
+		// A client retrying with the same Idempotency-Key gets the book
+		// created the first time instead of a second copy. The key is
+		// reserved up front, so even retries arriving at the same time
+		// create only one book.
+		idempotencyKey := c.Request().Header.Get(headerIdempotencyKey)
+		stored := false
+		if idempotencyKey != "" {
+			previous, err := reserveIdempotencyKey(idempotencyKeys, idempotencyKey)
+			if err != nil {
+				return respondError(c, http.StatusInternalServerError, codeInternal, "error in checking the idempotency key")
+			}
+			if previous != nil {
+				if previous.Book == nil {
+					return respondError(c, http.StatusConflict, codeConflict, "a request with this Idempotency-Key is still being processed")
+				}
+				return c.JSON(http.StatusCreated, previous.Book.ToDTO())
+			}
+			// Without a book stored, a retry may use the key again
+			defer func() {
+				if !stored {
+					releaseIdempotencyKey(idempotencyKeys, idempotencyKey)
+				}
+			}()
+		}
+
+		// Books without an ISBN are compared by their name and author,
+		// ignoring case, so the same book is not added twice by accident
+		if bookStore.BookISBN == "" {
+			duplicate := bson.M{
+				"bookname":   equalFoldFilter(bookStore.BookName),
+				"bookauthor": equalFoldFilter(bookStore.BookAuthor),
+			}
+			if err := coll.FindOne(context.TODO(), duplicate).Err(); err == nil {
+				return respondError(c, http.StatusConflict, codeConflict, "a book with the same name and author already exists")
+			}
+		}
+
+		// create field to compare
+		objToComapare := bson.M{}
+		if bookStore.BookName != "" {
+			objToComapare["bookname"] = bookStore.BookName
+		}
+		if bookStore.BookAuthor != "" {
+			objToComapare["bookauthor"] = bookStore.BookAuthor
+		}
+		if bookStore.BookPages != 0 {
+			objToComapare["bookpages"] = bookStore.BookPages
+		}
+		if bookStore.BookYear != 0 {
+			objToComapare["bookyear"] = bookStore.BookYear
+		}
+		if bookStore.BookISBN != "" {
+			objToComapare["bookisbn_digits"] = bookStore.BookISBNDigits
+		}
+
+		// check object existence
+		var existingBook BookStore
+		found := coll.FindOne(context.TODO(), objToComapare).Decode(&existingBook)
+		if found == nil {
+			return respondError(c, http.StatusConflict, codeConflict, "the book already exists")
+		}
+
+		bookStore.CreatedAt = time.Now().UTC()
+		bookStore.UpdatedAt = bookStore.CreatedAt
+		result, err := coll.InsertOne(context.TODO(), bookStore)
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, codeInternal, "error in inserting the book")
+		}
+		bookStore.ID = result.InsertedID.(primitive.ObjectID)
+		stored = true
+		if idempotencyKey != "" {
+			completeIdempotencyKey(idempotencyKeys, idempotencyKey, bookStore)
+		}
+
+		return c.JSON(http.StatusCreated, bookStore.ToDTO())
+	}, bookBody...)
+
+	// Deprecated: the ID travels inside the body here, prefer PUT /api/books/:id
+	e.PUT("/api/books", func(c echo.Context) error {
+		log.Println("warning: PUT /api/books is deprecated, use PUT /api/books/:id instead")
+		c.Response().Header().Set("Deprecation", "true")
+
+		book := new(BookDTO)
+		if err := c.Bind(book); err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidPayload, "error in payload conversion")
+		}
+		if err := c.Validate(book); err != nil {
+			return c.JSON(http.StatusBadRequest, ValidationResult{Valid: false, Errors: fieldErrors(err)})
+		}
+
+		objId, err := primitive.ObjectIDFromHex(book.Id)
+		if err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidID, "invalid id")
+		}
+		filter, conditional, err := updateFilter(c, objId)
+		if err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidQuery, err.Error())
+		}
+		book.Name = normalizeText(book.Name)
+		book.Author = normalizeText(book.Author)
+		book.Isbn = normalizeText(book.Isbn)
+		book.Tags = normalizeTags(book.Tags)
+		book.CoverURL = strings.TrimSpace(book.CoverURL)
+		if book.CoverURL != "" && !isWebURL(book.CoverURL) {
+			return c.JSON(http.StatusBadRequest, ValidationResult{
+				Valid:  false,
+				Errors: []FieldError{{Field: "coverUrl", Message: "must be an http or https URL"}},
+			})
+		}
+
+		objToComapare := bson.M{}
+		if book.Name != "" {
+			objToComapare["bookname"] = book.Name
+		}
+		if book.Author != "" {
+			objToComapare["bookauthor"] = book.Author
+			objToComapare["bookauthor_lower"] = authorKey(book.Author)
+		}
+		if book.Pages != 0 {
+			objToComapare["bookpages"] = book.Pages
+		}
+		if book.Year != 0 {
+			objToComapare["bookyear"] = book.Year
+		}
+		if book.Isbn != "" {
+			objToComapare["bookisbn"] = book.Isbn
+			objToComapare["bookisbn_digits"] = isbnKey(book.Isbn)
+		}
+		if len(book.Tags) > 0 {
+			objToComapare["tags"] = book.Tags
+		}
+		if book.CoverURL != "" {
+			objToComapare["coverurl"] = book.CoverURL
+		}
+		now := time.Now().UTC()
+		objToComapare["updatedat"] = now
+
+		// The book as it was before the update goes into its history
+		var previous BookStore
+		err = coll.FindOneAndUpdate(
+			context.TODO(),
+			filter,
+			bson.M{
+				"$set": objToComapare,
+			}).Decode(&previous)
+		if err == mongo.ErrNoDocuments {
+			return respondUpdateMiss(c, coll, objId, conditional)
+		}
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, codeInternal, "error in updating data")
+		}
+		recordHistory(history, historyUpdate, previous)
+		setLastModified(c, now)
+		return c.JSON(http.StatusOK, book)
+	})
+
+	// Imports a JSON array of books from a remote catalog, e.g.
+	// {"url": "https://example.org/books.json"}
+	e.POST("/api/books/import-url", func(c echo.Context) error {
+		var req struct {
+			URL string `json:"url"`
+		}
+		if err := c.Bind(&req); err != nil || req.URL == "" {
+			return respondError(c, http.StatusBadRequest, codeInvalidPayload, "a url is required")
+		}
+		books, err := fetchCatalog(req.URL)
+		if err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidPayload, err.Error())
+		}
+		incomplete, err := importDefaultsFromQuery(c, books, repo.ImportDefaultAuthor)
+		if err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidQuery, err.Error())
+		}
+		summary, err := importBooks(coll, books, limits)
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, codeInternal, "error in inserting the books")
+		}
+		summary.Incomplete = incomplete
+		return c.JSON(http.StatusOK, summary)
+	})
+
+	// Imports the books of a CSV body (see readBooksCSV), with the same
+	// ?delimiter= as the export
+	e.POST("/api/books/import.csv", func(c echo.Context) error {
+		delimiter, err := csvDelimiterFromQuery(c)
+		if err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidQuery, err.Error())
+		}
+		books, err := readBooksCSV(c.Request().Body, delimiter)
+		if err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidPayload, err.Error())
+		}
+		incomplete, err := importDefaultsFromQuery(c, books, repo.ImportDefaultAuthor)
+		if err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidQuery, err.Error())
+		}
+		summary, err := importBooks(coll, books, limits)
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, codeInternal, "error in inserting the books")
+		}
+		summary.Incomplete = incomplete
+		return c.JSON(http.StatusOK, summary)
+	})
+
+	// Inserts a JSON array of books at once. Invalid books are skipped and
+	// reported, but a batch using the same ISBN twice is rejected as a whole.
+	e.POST("/api/books/bulk", func(c echo.Context) error {
+		var books []PostBookDTO
+		if err := c.Bind(&books); err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidPayload, "expected a JSON array of books")
+		}
+		if len(books) > maxImportBooks {
+			return respondError(c, http.StatusBadRequest, codeInvalidPayload, fmt.Sprintf("at most %d books can be inserted at once", maxImportBooks))
+		}
+		if errs := duplicateISBNs(books); errs != nil {
+			return c.JSON(http.StatusBadRequest, ValidationResult{Valid: false, Errors: errs})
+		}
+		incomplete, err := importDefaultsFromQuery(c, books, repo.ImportDefaultAuthor)
+		if err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidQuery, err.Error())
+		}
+		summary, err := importBooks(coll, books, limits)
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, codeInternal, "error in inserting the books")
+		}
+		summary.Incomplete = incomplete
+		return c.JSON(http.StatusOK, summary)
+	})
+
+	// Reads back a backup of GET /api/books/export.json
+	e.POST("/api/books/restore", func(c echo.Context) error {
+		var books []BookDTO
+		if err := c.Bind(&books); err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidPayload, "expected the JSON array of an export")
+		}
+		if len(books) > maxImportBooks {
+			return respondError(c, http.StatusBadRequest, codeInvalidPayload, fmt.Sprintf("at most %d books can be restored at once", maxImportBooks))
+		}
+		summary, err := restoreBooks(coll, books, limits, c.Validate)
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, codeInternal, "error in restoring the books")
+		}
+		return c.JSON(http.StatusOK, summary)
+	})
+
+	// Replaces all fields of the book given in the path. Any ID sent in the
+	// body is ignored, so the target can never be ambiguous.
+	e.PUT("/api/books/:id", func(c echo.Context) error {
+		objId, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidID, "invalid id")
+		}
+		book := new(PostBookDTO)
+		if err := c.Bind(book); err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidPayload, "error in payload conversion")
+		}
+		if err := c.Validate(book); err != nil {
+			return c.JSON(http.StatusBadRequest, ValidationResult{Valid: false, Errors: fieldErrors(err)})
+		}
+		bookStore := book.ToStore()
+		if errs := validateBook(bookStore, limits); errs != nil {
+			return c.JSON(http.StatusBadRequest, ValidationResult{Valid: false, Errors: errs})
+		}
+		filter, conditional, err := updateFilter(c, objId)
+		if err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidQuery, err.Error())
+		}
+
+		// We get the book as it was before the update back, which goes
+		// into its history and keeps the timestamps for the response
+		now := time.Now().UTC()
+		var previous BookStore
+		err = coll.FindOneAndUpdate(
+			context.TODO(),
+			filter,
+			bson.M{
+				"$set": bson.M{
+					"bookname":         bookStore.BookName,
+					"bookauthor":       bookStore.BookAuthor,
+					"bookauthor_lower": bookStore.BookAuthorLower,
+					"bookpages":        bookStore.BookPages,
+					"bookyear":         bookStore.BookYear,
+					"bookisbn":         bookStore.BookISBN,
+					"bookisbn_digits":  bookStore.BookISBNDigits,
+					"tags":             bookStore.Tags,
+					"coverurl":         bookStore.CoverURL,
+					"updatedat":        now,
+				},
+			},
+		).Decode(&previous)
+		if err == mongo.ErrNoDocuments {
+			return respondUpdateMiss(c, coll, objId, conditional)
+		}
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, codeInternal, "error in updating data")
+		}
+		recordHistory(history, historyUpdate, previous)
+
+		bookStore.ID = objId
+		bookStore.CreatedAt = previous.CreatedAt
+		bookStore.UpdatedAt = now
+		setLastModified(c, now)
+		return c.JSON(http.StatusOK, bookStore.ToDTO())
+	}, bookBody...)
+
+	// Corrects the page counts of many books at once, e.g. after checking
+	// them against another catalog: [{"isbn": "...", "pages": 300}]. Every
+	// entry is reported as updated or with the reason it was not.
+	e.PATCH("/api/books/bulk-pages", func(c echo.Context) error {
+		var updates []PageUpdate
+		if err := c.Bind(&updates); err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidPayload, "expected a JSON array of isbns and pages")
+		}
+		if len(updates) > maxImportBooks {
+			return respondError(c, http.StatusBadRequest, codeInvalidPayload, fmt.Sprintf("at most %d books can be updated at once", maxImportBooks))
+		}
+		results, err := updatePagesByISBN(coll, updates, limits)
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, codeInternal, "error in updating the pages")
+		}
+		return c.JSON(http.StatusOK, results)
+	})
+
+	// Partial update with a JSON Merge Patch, e.g. {"pages": 300} changes
+	// the pages only and {"isbn": null} removes the ISBN (see
+	// mergePatchUpdate). The patched book has to pass the usual checks.
+	e.PATCH("/api/books/:id", func(c echo.Context) error {
+		objId, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidID, "invalid id")
+		}
+		contentType := strings.TrimSpace(strings.Split(c.Request().Header.Get(echo.HeaderContentType), ";")[0])
+		if contentType != mimeMergePatch {
+			return respondError(c, http.StatusUnsupportedMediaType, codeUnsupportedMedia, "the patch must be sent as "+mimeMergePatch)
+		}
+		var raw map[string]json.RawMessage
+		if err := json.NewDecoder(c.Request().Body).Decode(&raw); err != nil || raw == nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidPayload, "the patch must be a JSON object")
+		}
+		set, unset, errs := mergePatchUpdate(raw)
+		if errs != nil {
+			return c.JSON(http.StatusBadRequest, ValidationResult{Valid: false, Errors: errs})
+		}
+		filter, conditional, err := updateFilter(c, objId)
+		if err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidQuery, err.Error())
+		}
+
+		var current BookStore
+		err = coll.FindOne(context.TODO(), filter).Decode(&current)
+		if err == mongo.ErrNoDocuments {
+			return respondUpdateMiss(c, coll, objId, conditional)
+		}
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, codeInternal, "error in finding the book")
+		}
+		patched, err := patchedBook(current, set, unset)
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, codeInternal, "error in applying the patch")
+		}
+		if errs := validateBook(patched, limits); errs != nil {
+			return c.JSON(http.StatusBadRequest, ValidationResult{Valid: false, Errors: errs})
+		}
+
+		now := time.Now().UTC()
+		set["updatedat"] = now
+		update := bson.M{"$set": set}
+		if len(unset) > 0 {
+			update["$unset"] = unset
+		}
+		var previous BookStore
+		err = coll.FindOneAndUpdate(context.TODO(), filter, update).Decode(&previous)
+		if err == mongo.ErrNoDocuments {
+			return respondUpdateMiss(c, coll, objId, conditional)
+		}
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, codeInternal, "error in updating data")
+		}
+		recordHistory(history, historyUpdate, previous)
+
+		patched.UpdatedAt = now
+		setLastModified(c, now)
+		return c.JSON(http.StatusOK, patched.ToDTO())
+	})
+
+	// Shows how two books differ, e.g. to decide how to merge duplicates:
+	// {"a": "<id>", "b": "<id>"} (see diffBooks)
+	e.POST("/api/books/diff", func(c echo.Context) error {
+		req := new(DiffDTO)
+		if err := c.Bind(req); err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidPayload, "error in payload conversion")
+		}
+		idA, err := primitive.ObjectIDFromHex(req.A)
+		if err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidID, "invalid id a")
+		}
+		idB, err := primitive.ObjectIDFromHex(req.B)
+		if err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidID, "invalid id b")
+		}
+
+		var a, b BookStore
+		err = readColl.FindOne(context.TODO(), bson.M{"_id": idA}).Decode(&a)
+		if err == mongo.ErrNoDocuments {
+			return respondError(c, http.StatusNotFound, codeNotFound, "book a does not exist")
+		}
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, codeInternal, "error in finding the books")
+		}
+		err = readColl.FindOne(context.TODO(), bson.M{"_id": idB}).Decode(&b)
+		if err == mongo.ErrNoDocuments {
+			return respondError(c, http.StatusNotFound, codeNotFound, "book b does not exist")
+		}
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, codeInternal, "error in finding the books")
+		}
+		return c.JSON(http.StatusOK, diffBooks(a, b))
+	})
+
+	// Merges two records of the same book: the empty fields of "keep" are
+	// filled from "remove", which is deleted afterwards. Both end up in the
+	// history, so the merge can be traced back.
+	e.POST("/api/books/merge", func(c echo.Context) error {
+		req := new(MergeDTO)
+		if err := c.Bind(req); err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidPayload, "error in payload conversion")
+		}
+		keepId, err := primitive.ObjectIDFromHex(req.Keep)
+		if err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidID, "invalid keep id")
+		}
+		removeId, err := primitive.ObjectIDFromHex(req.Remove)
+		if err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidID, "invalid remove id")
+		}
+		if keepId == removeId {
+			return respondError(c, http.StatusBadRequest, codeInvalidPayload, "keep and remove must be different books")
+		}
+
+		var keep, remove BookStore
+		err = coll.FindOne(context.TODO(), bson.M{"_id": keepId}).Decode(&keep)
+		if err == mongo.ErrNoDocuments {
+			return respondError(c, http.StatusNotFound, codeNotFound, "the book to keep does not exist")
+		}
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, codeInternal, "error in finding the books")
+		}
+		err = coll.FindOne(context.TODO(), bson.M{"_id": removeId}).Decode(&remove)
+		if err == mongo.ErrNoDocuments {
+			return respondError(c, http.StatusNotFound, codeNotFound, "the book to remove does not exist")
+		}
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, codeInternal, "error in finding the books")
+		}
+
+		merged, set, filled := mergeBooks(keep, remove)
+		// ISBNs are unique (see bookIndexes), so an ISBN moving to the kept
+		// book has to be released by the removed one first. It gets it back
+		// if the kept book cannot be updated.
+		movedISBN, movesISBN := set["bookisbn_digits"]
+		if movesISBN {
+			if _, err := coll.UpdateOne(context.TODO(), bson.M{"_id": removeId}, bson.M{"$unset": bson.M{"bookisbn_digits": ""}}); err != nil {
+				return respondError(c, http.StatusInternalServerError, codeInternal, "error in updating the merged book")
+			}
+		}
+		if len(set) > 0 {
+			merged.UpdatedAt = time.Now().UTC()
+			set["updatedat"] = merged.UpdatedAt
+			if _, err := coll.UpdateOne(context.TODO(), bson.M{"_id": keepId}, bson.M{"$set": set}); err != nil {
+				if movesISBN {
+					if _, restoreErr := coll.UpdateOne(context.TODO(), bson.M{"_id": removeId}, bson.M{"$set": bson.M{"bookisbn_digits": movedISBN}}); restoreErr != nil {
+						log.Printf("warning: failed to give book %s its ISBN back after a failed merge: %v\n", removeId.Hex(), restoreErr)
+					}
+				}
+				if mongo.IsDuplicateKeyError(err) {
+					return respondError(c, http.StatusConflict, codeConflict, "another book already has the ISBN of the merged book")
+				}
+				return respondError(c, http.StatusInternalServerError, codeInternal, "error in updating the kept book")
+			}
+			recordHistory(history, historyUpdate, keep)
+		}
+		if _, err := coll.DeleteOne(context.TODO(), bson.M{"_id": removeId}); err != nil {
+			return respondError(c, http.StatusInternalServerError, codeInternal, "error in deleting the merged book")
+		}
+		recordHistory(history, historyDelete, remove)
+
+		return c.JSON(http.StatusOK, MergeResult{
+			Book:    merged.ToDTO(),
+			Filled:  filled,
+			Removed: req.Remove,
+		})
+	})
+
+	// Adds a single tag, leaving the other tags alone. $addToSet makes adding
+	// a tag the book already has a no-op.
+	e.POST("/api/books/:id/tags", func(c echo.Context) error {
+		objId, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidID, "invalid id")
+		}
+		body := new(TagDTO)
+		if err := c.Bind(body); err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidPayload, "error in payload conversion")
+		}
+		tags := normalizeTags([]string{body.Tag})
+		if len(tags) == 0 {
+			return respondError(c, http.StatusBadRequest, codeInvalidPayload, "tag must not be empty")
+		}
+
+		updated, err := updateTags(coll, objId, bson.M{"$addToSet": bson.M{"tags": tags[0]}})
+		if err == mongo.ErrNoDocuments {
+			return respondError(c, http.StatusNotFound, codeNotFound, "book does not exist")
+		}
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, codeInternal, "error in updating the tags")
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{"tags": updated})
+	})
+
+	// Removes a single tag from a book
+	e.DELETE("/api/books/:id/tags/:tag", func(c echo.Context) error {
+		objId, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidID, "invalid id")
+		}
+		tag, err := url.PathUnescape(c.Param("tag"))
+		if err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidQuery, "invalid tag")
+		}
+		tags := normalizeTags([]string{tag})
+		if len(tags) == 0 {
+			return respondError(c, http.StatusBadRequest, codeInvalidQuery, "tag must not be empty")
+		}
+
+		updated, err := updateTags(coll, objId, bson.M{"$pull": bson.M{"tags": tags[0]}})
+		if err == mongo.ErrNoDocuments {
+			return respondError(c, http.StatusNotFound, codeNotFound, "book does not exist")
+		}
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, codeInternal, "error in updating the tags")
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{"tags": updated})
+	})
+
+	// Bulk delete, e.g. DELETE /api/books?maxYear=1900&author=Mary%20Shelley
+	// At least one filter is required, so a bare DELETE can never wipe the
+	// whole collection, and the client has to confirm it via a header.
+	// Books without a year (stored as 0) are never matched by maxYear, and
+	// the author is matched ignoring case.
+	e.DELETE("/api/books", func(c echo.Context) error {
+		filter := bson.M{}
+		if maxYear := c.QueryParam("maxYear"); maxYear != "" {
+			year, err := strconv.Atoi(maxYear)
+			if err != nil {
+				return respondError(c, http.StatusBadRequest, codeInvalidQuery, "maxYear must be a number")
+			}
+			filter["bookyear"] = bson.M{"$gt": 0, "$lte": year}
+		}
+		if author := normalizeText(c.QueryParam("author")); author != "" {
+			filter["bookauthor_lower"] = authorKey(author)
+		}
+		if len(filter) == 0 {
+			return respondError(c, http.StatusBadRequest, codeInvalidQuery, "at least one filter (maxYear, author) is required")
+		}
+
+		if c.QueryParam("dryRun") == "true" {
+			books, err := findBookDTOs(coll, filter)
+			if err != nil {
+				return respondError(c, http.StatusInternalServerError, codeInternal, "error in finding the books")
+			}
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"dryRun": true,
+				"books":  books,
+			})
+		}
+
+		if c.Request().Header.Get("X-Confirm-Bulk-Delete") != "true" {
+			return respondError(c, http.StatusBadRequest, codeConfirmationRequired, "bulk delete must be confirmed with the X-Confirm-Bulk-Delete: true header")
+		}
+
+		// Snapshot the matching books first, so each one gets a history
+		// entry, and then delete exactly those
+		cursor, err := coll.Find(context.TODO(), filter)
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, codeInternal, "error in finding the books")
+		}
+		var matched []BookStore
+		if err = cursor.All(context.TODO(), &matched); err != nil {
+			return respondError(c, http.StatusInternalServerError, codeInternal, "error in finding the books")
+		}
+		ids := make([]primitive.ObjectID, 0, len(matched))
+		for _, book := range matched {
+			ids = append(ids, book.ID)
+		}
+
+		result, err := coll.DeleteMany(context.TODO(), bson.M{"_id": bson.M{"$in": ids}})
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, codeInternal, "error in deleting the books")
+		}
+		for _, book := range matched {
+			recordHistory(history, historyDelete, book)
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"deleted": result.DeletedCount,
+		})
+	})
+
+	e.DELETE("/api/books/:id", func(c echo.Context) error {
+		objId, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			return respondError(c, http.StatusBadRequest, codeInvalidID, "invalid id")
+		}
+
+		// With ?dryRun=true we only look the book up and report what would
+		// be deleted, without touching the collection
+		if c.QueryParam("dryRun") == "true" {
+			var book BookStore
+			err = coll.FindOne(context.TODO(), bson.M{"_id": objId}).Decode(&book)
+			if err == mongo.ErrNoDocuments {
+				return respondError(c, http.StatusNotFound, codeNotFound, "book does not exist")
+			}
+			if err != nil {
+				return respondError(c, http.StatusInternalServerError, codeInternal, "error in finding the book")
+			}
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"dryRun": true,
+				"book":   book.ToDTO(),
+			})
+		}
+
+		var deleted BookStore
+		err = coll.FindOneAndDelete(
+			context.TODO(),
+			bson.M{"_id": objId},
+		).Decode(&deleted)
+		if err == mongo.ErrNoDocuments {
+			return respondError(c, http.StatusNotFound, codeNotFound, "book does not exist")
+		}
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, codeInternal, "error in deleting the book")
+		}
+		recordHistory(history, historyDelete, deleted)
+		return c.JSON(http.StatusOK, "Book deleted successfully")
+	})
+
+	// Maintenance endpoints, only available when ADMIN_TOKEN is set
+	if repo.AdminToken != "" {
+		admin := e.Group("/api/admin", adminAuth(repo.AdminToken))
+
+		// Only one maintenance task runs at a time, a second call while one
+		// is running is turned away
+		var maintenance sync.Mutex
+
+		// Creates the missing indexes of the books collection, e.g. after a
+		// large import fixed the data that kept one from being built, and
+		// drops the stale ones. We are not ready until it succeeded.
+		admin.POST("/reindex", func(c echo.Context) error {
+			if !maintenance.TryLock() {
+				return respondError(c, http.StatusConflict, codeConflict, "a maintenance task is already running")
+			}
+			defer maintenance.Unlock()
+
+			indexesReady.Store(false)
+			names, err := reindexBooks(coll)
+			if err != nil {
+				log.Printf("reindex failed: %v", err)
+				return respondError(c, http.StatusInternalServerError, codeInternal, "error in rebuilding the indexes")
+			}
+			indexesReady.Store(true)
+			return c.JSON(http.StatusOK, map[string]interface{}{"indexes": names})
+		})
+
+		// Throws away every book and starts over with the sample books, for
+		// test and development setups. Refused unless ALLOW_RESET is true,
+		// so a production catalog cannot be wiped by accident.
+		admin.POST("/reset", func(c echo.Context) error {
+			if !repo.AllowReset {
+				return respondError(c, http.StatusForbidden, codeForbidden, "resetting is disabled, see ALLOW_RESET")
+			}
+			if !maintenance.TryLock() {
+				return respondError(c, http.StatusConflict, codeConflict, "a maintenance task is already running")
+			}
+			defer maintenance.Unlock()
+
+			count, err := resetBooks(coll, repo.CollectionOptions)
+			if err != nil {
+				log.Printf("reset failed: %v", err)
+				return respondError(c, http.StatusInternalServerError, codeInternal, "error in resetting the books")
+			}
+			log.Printf("warning: the books were reset, %d sample books inserted\n", count)
+			return c.JSON(http.StatusOK, map[string]interface{}{"count": count})
+		})
+	}
+
+	return e
+}
@@ -0,0 +1,670 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestDeleteDryRunKeepsBook(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		book := seedBooks()[1]
+
+		mt.AddMockResponses(booksReply(t, book))
+		rec := serve(e, http.MethodDelete, "/api/books/"+book.ID.Hex()+"?dryRun=true", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+		if !strings.Contains(rec.Body.String(), `"dryRun":true`) || !strings.Contains(rec.Body.String(), "Frankenstein") {
+			t.Errorf("body = %s, want the book that would be deleted", rec.Body.String())
+		}
+		if n := len(sentCommands(mt, "delete")) + len(sentCommands(mt, "findAndModify")); n != 0 {
+			t.Errorf("a dry run sent %d deletes", n)
+		}
+	})
+}
+
+func TestBulkDeleteByFilter(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		book := seedBooks()[1]
+
+		// The matching books, the delete and the history entry
+		mt.AddMockResponses(booksReply(t, book), writeReply(1), writeReply(1))
+		rec := serve(e, http.MethodDelete, "/api/books?maxYear=1900&author=MARY%20SHELLEY", "",
+			"X-Confirm-Bulk-Delete", "true")
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"deleted":1`) {
+			t.Fatalf("got %d %s, want one book deleted", rec.Code, rec.Body.String())
+		}
+
+		finds := sentCommands(mt, "find")
+		if len(finds) != 1 {
+			t.Fatalf("sent %d finds, want 1", len(finds))
+		}
+		filter := finds[0].Command.Lookup("filter").Document()
+		if got := filter.Lookup("bookauthor_lower").StringValue(); got != "mary shelley" {
+			t.Errorf("author filter = %q, want the lowercase mirror", got)
+		}
+		if _, ok := filter.Lookup("bookyear", "$gt").AsInt32OK(); !ok {
+			t.Errorf("year filter %s does not skip books without a year", filter.Lookup("bookyear"))
+		}
+	})
+}
+
+func TestBulkDeleteWithoutFilter(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+
+		rec := serve(e, http.MethodDelete, "/api/books", "", "X-Confirm-Bulk-Delete", "true")
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), codeInvalidQuery) {
+			t.Errorf("got %d %s, want 400 %s", rec.Code, rec.Body.String(), codeInvalidQuery)
+		}
+		if deletes := sentCommands(mt, "delete"); len(deletes) != 0 {
+			t.Errorf("sent %d deletes without a filter", len(deletes))
+		}
+	})
+}
+
+// The first document of the n-th insert the test sent
+func insertedDoc(t *testing.T, mt *mtest.T, n int) bson.Raw {
+	t.Helper()
+	inserts := sentCommands(mt, "insert")
+	if len(inserts) <= n {
+		t.Fatalf("sent %d inserts, want at least %d", len(inserts), n+1)
+	}
+	return inserts[n].Command.Lookup("documents").Array().Index(0).Value().Document()
+}
+
+func TestCreateNormalizesFields(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+
+		// No existing copy, then the insert
+		mt.AddMockResponses(booksReply(t), writeReply(1))
+		rec := serve(e, http.MethodPost, "/api/books",
+			`{"name":" Frankenstein ","author":"  Mary  Shelley  ","isbn":"978-3-649-64609-9","pages":280,"year":1818}`)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("status = %d, want 201: %s", rec.Code, rec.Body.String())
+		}
+
+		doc := insertedDoc(t, mt, 0)
+		if got := doc.Lookup("bookauthor").StringValue(); got != "Mary Shelley" {
+			t.Errorf("stored author = %q, want %q", got, "Mary Shelley")
+		}
+		if got := doc.Lookup("bookname").StringValue(); got != "Frankenstein" {
+			t.Errorf("stored name = %q, want %q", got, "Frankenstein")
+		}
+	})
+}
+
+func TestRandomBooksCount(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		books := seedBooks()
+
+		// $sample handing out Frankenstein twice
+		mt.AddMockResponses(booksReply(t, books[1], books[0], books[1], books[2]))
+		rec := serve(e, http.MethodGet, "/api/books/random?count=3", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+		var got []BookDTO
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		ids := map[string]bool{}
+		for _, book := range got {
+			ids[book.Id] = true
+		}
+		if len(got) != 3 || len(ids) != 3 {
+			t.Errorf("got %d books, %d distinct, want 3 distinct", len(got), len(ids))
+		}
+
+		pipeline := sentCommands(mt, "aggregate")[0].Command.Lookup("pipeline").Array()
+		if size := pipeline.Index(0).Value().Document().Lookup("$sample", "size").AsInt64(); size != 3 {
+			t.Errorf("sampled %d books, want 3", size)
+		}
+	})
+}
+
+func TestBookTableEscapesNames(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		book := seedBooks()[0]
+		book.BookName = "<script>alert(1)</script>"
+
+		mt.AddMockResponses(booksReply(t, book))
+		rec := serve(e, http.MethodGet, "/books", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200", rec.Code)
+		}
+		if strings.Contains(rec.Body.String(), "<script>alert") {
+			t.Errorf("the name was rendered unescaped:\n%s", rec.Body.String())
+		}
+		if !strings.Contains(rec.Body.String(), "&lt;script&gt;alert(1)&lt;/script&gt;") {
+			t.Errorf("the escaped name is missing:\n%s", rec.Body.String())
+		}
+	})
+}
+
+func TestUpdateByPath(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		book := seedBooks()[1]
+
+		// The book before the update, then its history entry
+		mt.AddMockResponses(findAndModifyReply(t, book), writeReply(1))
+		rec := serve(e, http.MethodPut, "/api/books/"+book.ID.Hex(),
+			`{"name":"Frankenstein","author":"Mary Shelley","isbn":"978-3-649-64609-9","pages":300,"year":1818}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+		var got BookDTO
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Id != book.ID.Hex() || got.Pages != 300 {
+			t.Errorf("got %+v, want book %s with 300 pages", got, book.ID.Hex())
+		}
+
+		command := sentCommands(mt, "findAndModify")[0].Command
+		if id := command.Lookup("query", "_id").ObjectID(); id != book.ID {
+			t.Errorf("updated book %s, want the one of the path %s", id.Hex(), book.ID.Hex())
+		}
+		if pages := command.Lookup("update", "$set", "bookpages").AsInt64(); pages != 300 {
+			t.Errorf("set %d pages, want 300", pages)
+		}
+	})
+}
+
+func TestCreateRejectsSameTitleAndAuthor(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		payload := `{"name":"The Black Cat","author":"Edgar Allan Poe","pages":280,"year":1843}`
+
+		// Neither a copy by name and author nor an identical book, then the insert
+		mt.AddMockResponses(booksReply(t), booksReply(t), writeReply(1))
+		if rec := serve(e, http.MethodPost, "/api/books", payload); rec.Code != http.StatusCreated {
+			t.Fatalf("first create: status = %d, want 201: %s", rec.Code, rec.Body.String())
+		}
+
+		// The second time the copy is found, whatever the case
+		stored := seedBooks()[2]
+		stored.BookISBN, stored.BookISBNDigits = "", ""
+		mt.AddMockResponses(booksReply(t, stored))
+		rec := serve(e, http.MethodPost, "/api/books", strings.ToUpper(payload))
+		if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), codeConflict) {
+			t.Errorf("second create: got %d %s, want 409 %s", rec.Code, rec.Body.String(), codeConflict)
+		}
+		if inserts := sentCommands(mt, "insert"); len(inserts) != 1 {
+			t.Errorf("sent %d inserts, want 1", len(inserts))
+		}
+	})
+}
+
+func TestSimilarBooks(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		source := seedBooks()[1]
+		other := source
+		other.ID = primitive.NewObjectID()
+		other.BookName, other.BookYear = "The Last Man", 1826
+
+		mt.AddMockResponses(booksReply(t, source), booksReply(t, other))
+		rec := serve(e, http.MethodGet, "/api/books/"+source.ID.Hex()+"/similar", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+		var got []BookDTO
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 || got[0].Name != "The Last Man" {
+			t.Errorf("got %+v, want The Last Man", got)
+		}
+
+		filter := sentCommands(mt, "find")[1].Command.Lookup("filter").Document()
+		if id := filter.Lookup("_id", "$ne").ObjectID(); id != source.ID {
+			t.Errorf("the source book %s is not excluded: %s", source.ID.Hex(), filter)
+		}
+		author := filter.Lookup("$or").Array().Index(0).Value().Document().Lookup("bookauthor").StringValue()
+		if author != "Mary Shelley" {
+			t.Errorf("matched author %q, want Mary Shelley", author)
+		}
+	})
+}
+
+func TestTimestamps(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		payload := `{"name":"Frankenstein","author":"Mary Shelley","isbn":"978-3-649-64609-9","pages":280,"year":1818}`
+
+		mt.AddMockResponses(booksReply(t), writeReply(1))
+		rec := serve(e, http.MethodPost, "/api/books", payload)
+		var created BookDTO
+		if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+			t.Fatal(err)
+		}
+		if created.CreatedAt == nil || created.UpdatedAt == nil || !created.CreatedAt.Equal(*created.UpdatedAt) {
+			t.Fatalf("created %+v, want equal timestamps", created)
+		}
+		if _, ok := insertedDoc(t, mt, 0).Lookup("createdat").TimeOK(); !ok {
+			t.Error("createdat was not stored")
+		}
+
+		// Updating the stored version, created a while ago
+		stored := seedBooks()[1]
+		mt.AddMockResponses(findAndModifyReply(t, stored), writeReply(1))
+		rec = serve(e, http.MethodPut, "/api/books/"+stored.ID.Hex(), payload)
+		var updated BookDTO
+		if err := json.Unmarshal(rec.Body.Bytes(), &updated); err != nil {
+			t.Fatal(err)
+		}
+		if updated.CreatedAt == nil || !updated.CreatedAt.Equal(stored.CreatedAt) {
+			t.Errorf("createdAt = %v, want it kept at %v", updated.CreatedAt, stored.CreatedAt)
+		}
+		if updated.UpdatedAt == nil || !updated.UpdatedAt.After(stored.UpdatedAt) {
+			t.Errorf("updatedAt = %v, want it after %v", updated.UpdatedAt, stored.UpdatedAt)
+		}
+	})
+}
+
+func TestDetailPage(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		book := seedBooks()[0]
+
+		mt.AddMockResponses(booksReply(t, book))
+		rec := serve(e, http.MethodGet, "/book/"+book.ID.Hex(), "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200", rec.Code)
+		}
+		for _, want := range []string{"The Vortex", "José Eustasio Rivera"} {
+			if !strings.Contains(rec.Body.String(), want) {
+				t.Errorf("detail page lacks %q:\n%s", want, rec.Body.String())
+			}
+		}
+
+		mt.AddMockResponses(booksReply(t))
+		if rec = serve(e, http.MethodGet, "/book/"+primitive.NewObjectID().Hex(), ""); rec.Code != http.StatusNotFound {
+			t.Errorf("missing book: status = %d, want 404", rec.Code)
+		}
+	})
+}
+
+func TestBooksOfAuthor(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+
+		mt.AddMockResponses(booksReply(t, seedBooks()[1]))
+		rec := serve(e, http.MethodGet, "/api/authors/Mary%20Shelley/books", "")
+		var got []BookDTO
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("body %s: %v", rec.Body.String(), err)
+		}
+		if len(got) != 1 || got[0].Name != "Frankenstein" {
+			t.Errorf("got %+v, want Frankenstein", got)
+		}
+		filter := sentCommands(mt, "find")[0].Command.Lookup("filter").Document()
+		if author := filter.Lookup("bookauthor_lower").StringValue(); author != "mary shelley" {
+			t.Errorf("filtered by %q, want the lowercase name", author)
+		}
+	})
+}
+
+func TestRenameAuthor(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+
+		mt.AddMockResponses(writeReply(2))
+		rec := serve(e, http.MethodPut, "/api/authors", `{"from": "Mary  Shelly", "to": "Mary Shelley"}`)
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"modified":2`) {
+			t.Fatalf("got %d %s, want two books renamed", rec.Code, rec.Body.String())
+		}
+		update := sentCommands(mt, "update")[0].Command.Lookup("updates").Array().Index(0).Value().Document()
+		if from, _ := update.Lookup("q", "bookauthor").StringValueOK(); from != "Mary Shelly" {
+			t.Errorf("renamed the books of %q, want Mary Shelly", from)
+		}
+		if multi, _ := update.Lookup("multi").BooleanOK(); !multi {
+			t.Error("only one book was renamed")
+		}
+		if lower, _ := update.Lookup("u", "$set", "bookauthor_lower").StringValueOK(); lower != "mary shelley" {
+			t.Errorf("bookauthor_lower = %q, want it renamed too", lower)
+		}
+
+		if rec = serve(e, http.MethodPut, "/api/authors", `{"from": "Mary Shelley", "to": " Mary Shelley "}`); rec.Code != http.StatusBadRequest {
+			t.Errorf("renaming to the same name: status = %d, want 400", rec.Code)
+		}
+	})
+}
+
+func TestCreateExistingBookConflicts(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		existing := seedBooks()[1]
+
+		mt.AddMockResponses(booksReply(t, existing))
+		rec := serve(e, http.MethodPost, "/api/books",
+			`{"name":"Frankenstein","author":"Mary Shelley","isbn":"9783649646099","pages":280,"year":1818}`)
+		if rec.Code != http.StatusConflict {
+			t.Errorf("status = %d, want 409: %s", rec.Code, rec.Body.String())
+		}
+
+		// Every key of the duplicate check has to be a field of the stored
+		// book holding the same value, or the check never matches
+		stored, err := bson.Marshal(existing)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filter, err := sentCommands(mt, "find")[0].Command.Lookup("filter").Document().Elements()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, element := range filter {
+			value, err := bson.Raw(stored).LookupErr(element.Key())
+			if err != nil {
+				t.Errorf("the filter uses %q, which the stored book does not have", element.Key())
+				continue
+			}
+			if !element.Value().Equal(value) {
+				t.Errorf("the filter wants %s = %s, the stored book has %s", element.Key(), element.Value(), value)
+			}
+		}
+	})
+}
+
+// Every route of the API, as registered by registerRoutes
+var expectedRoutes = []struct{ method, path string }{
+	{http.MethodGet, "/"},
+	{http.MethodGet, "/books"},
+	{http.MethodGet, "/book/:id"},
+	{http.MethodGet, "/authors"},
+	{http.MethodGet, "/years"},
+	{http.MethodGet, "/search"},
+	{http.MethodGet, "/create"},
+	{http.MethodPost, "/create"},
+	{http.MethodPost, "/book/:id/delete"},
+	{http.MethodGet, "/api/books"},
+	{http.MethodHead, "/api/books"},
+	{http.MethodGet, "/api/books/by-isbn/:isbn"},
+	{http.MethodGet, "/api/books/recent"},
+	{http.MethodGet, "/api/books/query"},
+	{http.MethodGet, "/api/books/random"},
+	{http.MethodGet, "/api/books/export.csv"},
+	{http.MethodGet, "/api/books/export.json"},
+	{http.MethodGet, "/api/books/incomplete"},
+	{http.MethodGet, "/api/books/:id"},
+	{http.MethodHead, "/api/books/:id"},
+	{http.MethodGet, "/api/books/:id/similar"},
+	{http.MethodGet, "/api/books/:id/before"},
+	{http.MethodGet, "/api/books/:id/after"},
+	{http.MethodGet, "/api/ping"},
+	{http.MethodGet, "/livez"},
+	{http.MethodGet, "/readyz"},
+	{http.MethodGet, "/api/authors"},
+	{http.MethodGet, "/api/authors/index"},
+	{http.MethodPut, "/api/authors"},
+	{http.MethodGet, "/api/authors/:author/books"},
+	{http.MethodGet, "/api/years"},
+	{http.MethodGet, "/api/books/:id/history"},
+	{http.MethodGet, "/api/stats/authors"},
+	{http.MethodGet, "/api/stats/timeline"},
+	{http.MethodGet, "/api/stats/pages"},
+	{http.MethodGet, "/api/stats/page-buckets"},
+	{http.MethodGet, "/api/stats/by-year"},
+	{http.MethodPost, "/api/books/validate"},
+	{http.MethodPost, "/api/books"},
+	{http.MethodPut, "/api/books"},
+	{http.MethodPost, "/api/books/import-url"},
+	{http.MethodPost, "/api/books/import.csv"},
+	{http.MethodPost, "/api/books/bulk"},
+	{http.MethodPost, "/api/books/restore"},
+	{http.MethodPut, "/api/books/:id"},
+	{http.MethodPatch, "/api/books/bulk-pages"},
+	{http.MethodPatch, "/api/books/:id"},
+	{http.MethodPost, "/api/books/diff"},
+	{http.MethodPost, "/api/books/merge"},
+	{http.MethodPost, "/api/books/:id/tags"},
+	{http.MethodDelete, "/api/books/:id/tags/:tag"},
+	{http.MethodDelete, "/api/books"},
+	{http.MethodDelete, "/api/books/:id"},
+	{http.MethodPost, "/api/admin/reindex"},
+	{http.MethodPost, "/api/admin/reset"},
+}
+
+func TestRoutesRegisteredOnce(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		repo := testRepo(mt)
+		repo.AdminToken = "secret"
+		e := newTestServer(repo)
+
+		registered := map[string]int{}
+		for _, route := range e.Routes() {
+			registered[route.Method+" "+route.Path]++
+		}
+		for route, n := range registered {
+			if n > 1 {
+				t.Errorf("%s is registered %d times", route, n)
+			}
+		}
+		for _, route := range expectedRoutes {
+			if registered[route.method+" "+route.path] == 0 {
+				t.Errorf("%s %s is not registered", route.method, route.path)
+			}
+		}
+	})
+}
+
+func TestCoverURLRoundTrip(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		const cover = "https://example.org/frankenstein.jpg"
+
+		mt.AddMockResponses(booksReply(t), writeReply(1))
+		rec := serve(e, http.MethodPost, "/api/books",
+			`{"name":"Frankenstein","author":"Mary Shelley","isbn":"978-3-649-64609-9","coverUrl":"`+cover+`"}`)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("status = %d, want 201: %s", rec.Code, rec.Body.String())
+		}
+		stored := insertedDoc(t, mt, 0)
+		if got := stored.Lookup("coverurl").StringValue(); got != cover {
+			t.Fatalf("stored cover = %q, want %q", got, cover)
+		}
+
+		id := stored.Lookup("_id").ObjectID().Hex()
+		mt.AddMockResponses(cursorReply(t, stored))
+		rec = serve(e, http.MethodGet, "/api/books/"+id, "")
+		var got BookDTO
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.CoverURL != cover {
+			t.Errorf("read back cover %q, want %q", got.CoverURL, cover)
+		}
+	})
+}
+
+func TestCoverURLRejectsJavaScript(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+
+		rec := serve(e, http.MethodPost, "/api/books",
+			`{"name":"Frankenstein","author":"Mary Shelley","isbn":"978-3-649-64609-9","coverUrl":"javascript:alert(1)"}`)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want 400", rec.Code)
+		}
+		if fields := invalidFields(t, rec.Body.Bytes()); len(fields) != 1 || fields[0] != "coverUrl" {
+			t.Errorf("invalid fields = %v, want [coverUrl]", fields)
+		}
+		if inserts := sentCommands(mt, "insert"); len(inserts) != 0 {
+			t.Error("the book was stored anyway")
+		}
+	})
+}
+
+func TestNothingBeforeFrankenstein(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		books := seedBooks()
+		frankenstein := books[1]
+
+		// Frankenstein (1818) is the oldest of the seed books
+		mt.AddMockResponses(booksReply(t, frankenstein), booksReply(t))
+		rec := serve(e, http.MethodGet, "/api/books/"+frankenstein.ID.Hex()+"/before", "")
+		if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != "[]" {
+			t.Errorf("got %d %s, want an empty list", rec.Code, rec.Body.String())
+		}
+
+		years := sentCommands(mt, "find")[1].Command.Lookup("filter", "bookyear").Document()
+		lt, gt := years.Lookup("$lt").AsInt64(), years.Lookup("$gt").AsInt64()
+		if lt != 1818 || gt != 0 {
+			t.Fatalf("filter %s, want the years between 0 and 1818", years)
+		}
+		for _, book := range books {
+			if int64(book.BookYear) < lt && int64(book.BookYear) > gt {
+				t.Errorf("the filter %s matches %s (%d)", years, book.BookName, book.BookYear)
+			}
+		}
+	})
+}
+
+func TestBooksPageWithMalformedDocument(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		books := seedBooks()
+
+		// Pages stored as a string, e.g. by a bad import
+		malformed := bson.M{"_id": primitive.NewObjectID(), "bookname": "Dracula", "bookauthor": "Bram Stoker", "bookpages": "418"}
+		mt.AddMockResponses(cursorReply(t, books[0], malformed, books[1]))
+		rec := serve(e, http.MethodGet, "/books", "")
+		if rec.Code != http.StatusInternalServerError {
+			t.Errorf("status = %d, want 500", rec.Code)
+		}
+		if !strings.Contains(rec.Body.String(), "could not be loaded") {
+			t.Errorf("body %s, want the error page", rec.Body.String())
+		}
+	})
+}
+
+func TestMixedCaseAuthorUsesMirror(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		frankenstein := seedBooks()[1]
+
+		mt.AddMockResponses(booksReply(t, frankenstein))
+		rec := serve(e, http.MethodGet, "/api/authors/mARY%20sHELLEY/books", "")
+		var got []BookDTO
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("body %s: %v", rec.Body.String(), err)
+		}
+		if len(got) != 1 || got[0].Author != "Mary Shelley" {
+			t.Errorf("got %+v, want Frankenstein as spelled when stored", got)
+		}
+
+		// A plain equality on the mirror, which the index can answer, equal
+		// to what was stored along with the book
+		filter := sentCommands(mt, "find")[0].Command.Lookup("filter").Document()
+		value := filter.Lookup("bookauthor_lower")
+		if value.Type != bson.TypeString || value.StringValue() != frankenstein.BookAuthorLower {
+			t.Errorf("filter %s, want bookauthor_lower equal to %q", filter, frankenstein.BookAuthorLower)
+		}
+	})
+}
+
+func TestBookByISBN(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		frankenstein := seedBooks()[1]
+
+		mt.AddMockResponses(booksReply(t, frankenstein))
+		rec := serve(e, http.MethodGet, "/api/books/by-isbn/"+frankenstein.BookISBN, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+		var got BookDTO
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Id != frankenstein.ID.Hex() || got.Isbn != frankenstein.BookISBN {
+			t.Errorf("got %+v, want Frankenstein", got)
+		}
+
+		// Nothing stored under a valid ISBN
+		mt.AddMockResponses(booksReply(t))
+		if rec := serve(e, http.MethodGet, "/api/books/by-isbn/978-3-99168-238-7", ""); rec.Code != http.StatusNotFound {
+			t.Errorf("status = %d for an unknown ISBN, want 404", rec.Code)
+		}
+	})
+}
+
+func TestISBNLookupWithAndWithoutHyphens(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+
+		mt.AddMockResponses(booksReply(t), writeReply(1))
+		rec := serve(e, http.MethodPost, "/api/books", `{"name":"Frankenstein","author":"Mary Shelley","isbn":"978-3-649-64609-9"}`)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("status = %d, want 201: %s", rec.Code, rec.Body.String())
+		}
+		stored := insertedDoc(t, mt, 0)
+		digits := stored.Lookup("bookisbn_digits").StringValue()
+
+		for i, isbn := range []string{"978-3-649-64609-9", "9783649646099", "978 3 649 64609 9"} {
+			mt.AddMockResponses(cursorReply(t, stored))
+			if rec := serve(e, http.MethodGet, "/api/books/by-isbn/"+url.PathEscape(isbn), ""); rec.Code != http.StatusOK {
+				t.Errorf("%q: status = %d, want 200", isbn, rec.Code)
+			}
+			// The exists check of the create was the first find
+			filter := sentCommands(mt, "find")[i+1].Command.Lookup("filter").Document()
+			if got := filter.Lookup("bookisbn_digits").StringValue(); got != digits {
+				t.Errorf("%q looks up %q, want the stored %q", isbn, got, digits)
+			}
+		}
+	})
+}
+
+// HEAD answers with the headers GET would send, without the body
+func TestHeadBook(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		book := seedBooks()[1]
+
+		mt.AddMockResponses(booksReply(t, book))
+		get := serve(e, http.MethodGet, "/api/books/"+book.ID.Hex(), "")
+		if get.Code != http.StatusOK {
+			t.Fatalf("GET: status = %d, want 200: %s", get.Code, get.Body.String())
+		}
+		mt.AddMockResponses(booksReply(t, book))
+		head := serve(e, http.MethodHead, "/api/books/"+book.ID.Hex(), "")
+		if head.Code != http.StatusOK {
+			t.Fatalf("HEAD: status = %d, want 200", head.Code)
+		}
+		if head.Body.Len() != 0 {
+			t.Errorf("HEAD sent a body: %s", head.Body.String())
+		}
+		if length := head.Header().Get(echo.HeaderContentLength); length != strconv.Itoa(get.Body.Len()) {
+			t.Errorf("Content-Length = %q, want %d, the size of the GET body", length, get.Body.Len())
+		}
+		if got, want := head.Header().Get(echo.HeaderContentType), get.Header().Get(echo.HeaderContentType); got != want {
+			t.Errorf("Content-Type = %q, want %q as for GET", got, want)
+		}
+
+		mt.AddMockResponses(booksReply(t))
+		head = serve(e, http.MethodHead, "/api/books/"+primitive.NewObjectID().Hex(), "")
+		if head.Code != http.StatusNotFound {
+			t.Errorf("HEAD of a missing book: status = %d, want 404", head.Code)
+		}
+		if head.Body.Len() != 0 {
+			t.Errorf("HEAD of a missing book sent a body: %s", head.Body.String())
+		}
+	})
+}
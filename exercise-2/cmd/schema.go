@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Schema of the book payloads of POST /api/books and PUT /api/books/:id,
+// compiled into the binary
+//
+//go:embed schema/book.schema.json
+var bookSchemaJSON []byte
+
+// The part of JSON Schema we need: types, properties, required fields,
+// array items and additionalProperties: false. Anything else in the schema
+// file is ignored.
+// More on JSON Schema: https://json-schema.org/understanding-json-schema/
+type JSONSchema struct {
+	Type                 string                 `json:"type"`
+	Properties           map[string]*JSONSchema `json:"properties"`
+	Required             []string               `json:"required"`
+	Items                *JSONSchema            `json:"items"`
+	AdditionalProperties *bool                  `json:"additionalProperties"`
+}
+
+func loadBookSchema() (*JSONSchema, error) {
+	var schema JSONSchema
+	if err := json.Unmarshal(bookSchemaJSON, &schema); err != nil {
+		return nil, fmt.Errorf("invalid book schema: %v", err)
+	}
+	return &schema, nil
+}
+
+// Checks a decoded JSON value against the schema and returns one entry per
+// problem, using the path of the value as field (e.g. "tags[1]")
+func (s *JSONSchema) Validate(path string, value interface{}) []FieldError {
+	var errs []FieldError
+	if s.Type != "" && !hasJSONType(value, s.Type) {
+		return append(errs, FieldError{Field: path, Message: "must be of type " + s.Type})
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				errs = append(errs, FieldError{Field: joinPath(path, name), Message: "is required"})
+			}
+		}
+		// Sorted, so the errors come in a stable order
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					errs = append(errs, FieldError{Field: joinPath(path, name), Message: "is not allowed"})
+				}
+				continue
+			}
+			errs = append(errs, property.Validate(joinPath(path, name), v[name])...)
+		}
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range v {
+				errs = append(errs, s.Items.Validate(fmt.Sprintf("%s[%d]", path, i), item)...)
+			}
+		}
+	}
+	return errs
+}
+
+func hasJSONType(value interface{}, typ string) bool {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return typ == "object"
+	case []interface{}:
+		return typ == "array"
+	case string:
+		return typ == "string"
+	case bool:
+		return typ == "boolean"
+	case nil:
+		return typ == "null"
+	case json.Number:
+		if typ == "number" {
+			return true
+		}
+		// Int64 refuses fractions like 1.5
+		_, err := v.Int64()
+		return typ == "integer" && err == nil
+	}
+	return false
+}
+
+func joinPath(path string, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// Rejects bodies not matching the schema with a 400 listing the problems,
+// before the handler binds them to a DTO. Binding alone would silently drop
+// unknown fields. The body is put back for the handler to read.
+func strictBody(schema *JSONSchema) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			body, err := io.ReadAll(c.Request().Body)
+			if err != nil {
+				return respondError(c, http.StatusBadRequest, codeInvalidPayload, "failed to read the body")
+			}
+			decoder := json.NewDecoder(bytes.NewReader(body))
+			decoder.UseNumber()
+			var value interface{}
+			if err := decoder.Decode(&value); err != nil {
+				return respondError(c, http.StatusBadRequest, codeInvalidPayload, "the body is not valid JSON")
+			}
+			if errs := schema.Validate("", value); errs != nil {
+				return c.JSON(http.StatusBadRequest, ValidationResult{Valid: false, Errors: errs})
+			}
+			c.Request().Body = io.NopCloser(bytes.NewReader(body))
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+const withPublisherJSON = `{"name":"Frankenstein","author":"Mary Shelley","isbn":"978-3-649-64609-9","publisher":"Lackington"}`
+
+func TestStrictValidationRejectsUnknownField(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		schema, err := loadBookSchema()
+		if err != nil {
+			t.Fatal(err)
+		}
+		repo := testRepo(mt)
+		repo.BookSchema = schema
+		e := newTestServer(repo)
+
+		rec := serve(e, http.MethodPost, "/api/books", withPublisherJSON)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want 400", rec.Code)
+		}
+		if fields := invalidFields(t, rec.Body.Bytes()); !slices.Equal(fields, []string{"publisher"}) {
+			t.Errorf("invalid fields = %v, want [publisher]", fields)
+		}
+		if started := mt.GetAllStartedEvents(); len(started) != 0 {
+			t.Errorf("sent %d commands for a rejected body", len(started))
+		}
+	})
+}
+
+func TestUnknownFieldIgnoredWithoutStrictValidation(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+
+		mt.AddMockResponses(booksReply(t), writeReply(1))
+		rec := serve(e, http.MethodPost, "/api/books", withPublisherJSON)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("status = %d, want 201: %s", rec.Code, rec.Body.String())
+		}
+		if _, err := insertedDoc(t, mt, 0).LookupErr("publisher"); err == nil {
+			t.Error("the unknown field was stored")
+		}
+	})
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type AuthorCount struct {
+	Author string `json:"author" bson:"_id"`
+	Count  int    `json:"count" bson:"count"`
+}
+
+// Counts the books of every author, most prolific authors first
+func aggregateAuthorCounts(coll *mongo.Collection) ([]AuthorCount, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{"_id": "$bookauthor", "count": bson.M{"$sum": 1}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+	}
+	defer timeQuery(coll, "aggregate", pipeline)()
+	cursor, err := coll.Aggregate(context.TODO(), pipeline)
+	if err != nil {
+		return nil, err
+	}
+	stats := make([]AuthorCount, 0)
+	if err = cursor.All(context.TODO(), &stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+type PageStats struct {
+	Total   int64   `json:"total" bson:"total"`
+	Average float64 `json:"average" bson:"average"`
+	Min     int     `json:"min" bson:"min"`
+	Max     int     `json:"max" bson:"max"`
+}
+
+// Sums up the page counts of all books. Books without a page count (stored
+// as 0) would drag the average down, so they are left out of it; $avg skips
+// the nulls the $cond turns them into. An empty collection yields zeros.
+func aggregatePageStats(coll *mongo.Collection) (PageStats, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{
+			"_id":   nil,
+			"total": bson.M{"$sum": "$bookpages"},
+			"average": bson.M{"$avg": bson.M{
+				"$cond": bson.A{bson.M{"$gt": bson.A{"$bookpages", 0}}, "$bookpages", nil},
+			}},
+			"min": bson.M{"$min": "$bookpages"},
+			"max": bson.M{"$max": "$bookpages"},
+		}}},
+	}
+	var stats PageStats
+	defer timeQuery(coll, "aggregate", pipeline)()
+	cursor, err := coll.Aggregate(context.TODO(), pipeline)
+	if err != nil {
+		return stats, err
+	}
+	var results []PageStats
+	if err = cursor.All(context.TODO(), &results); err != nil {
+		return stats, err
+	}
+	if len(results) > 0 {
+		stats = results[0]
+	}
+	return stats, nil
+}
+
+// Page ranges of /api/stats/page-buckets, by their upper bounds. The last
+// bucket takes every book above the last bound.
+var defaultPageBounds = []int{100, 200, 300}
+
+type PageBucket struct {
+	Range string `json:"range"`
+	Count int    `json:"count"`
+}
+
+// Counts the books per page range, e.g. 0-100, 101-200 and 200+ for the
+// bounds 100 and 200. Every range is listed, the empty ones with 0. Books
+// without a page count (stored as 0) are in the first range.
+// More on $bucket: https://www.mongodb.com/docs/manual/reference/operator/aggregation/bucket/
+func aggregatePageBuckets(coll *mongo.Collection, bounds []int) ([]PageBucket, error) {
+	// $bucket wants the lower bounds of the ranges plus the end of the last
+	boundaries := bson.A{0}
+	buckets := make([]PageBucket, 0, len(bounds)+1)
+	lower := 0
+	for _, upper := range bounds {
+		boundaries = append(boundaries, upper+1)
+		buckets = append(buckets, PageBucket{Range: fmt.Sprintf("%d-%d", lower, upper)})
+		lower = upper + 1
+	}
+	buckets = append(buckets, PageBucket{Range: fmt.Sprintf("%d+", bounds[len(bounds)-1])})
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$bucket", Value: bson.M{
+			"groupBy":    bson.M{"$ifNull": bson.A{"$bookpages", 0}},
+			"boundaries": boundaries,
+			"default":    "more",
+			"output":     bson.M{"count": bson.M{"$sum": 1}},
+		}}},
+	}
+	defer timeQuery(coll, "aggregate", pipeline)()
+	cursor, err := coll.Aggregate(context.TODO(), pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var results []struct {
+		ID    interface{} `bson:"_id"`
+		Count int         `bson:"count"`
+	}
+	if err = cursor.All(context.TODO(), &results); err != nil {
+		return nil, err
+	}
+
+	// A bucket is named after its lower bound, the default one after "more"
+	for _, result := range results {
+		index := len(buckets) - 1
+		switch id := result.ID.(type) {
+		case int32:
+			index = slices.Index(boundaries, interface{}(int(id)))
+		case int64:
+			index = slices.Index(boundaries, interface{}(int(id)))
+		case float64:
+			index = slices.Index(boundaries, interface{}(int(id)))
+		}
+		if index >= 0 && index < len(buckets) {
+			buckets[index].Count = result.Count
+		}
+	}
+	return buckets, nil
+}
+
+type TimelineBucket struct {
+	Period string `json:"period" bson:"_id"`
+	Count  int    `json:"count" bson:"count"`
+}
+
+// Formats of the periods /api/stats/timeline groups by, in the syntax of
+// $dateToString. They sort chronologically as plain strings.
+var timelineIntervals = map[string]string{
+	"day":   "%Y-%m-%d",
+	"month": "%Y-%m",
+}
+
+// Counts the books added per day or month (see timelineIntervals), oldest
+// first. Books stored before we tracked createdat are left out.
+func aggregateTimeline(coll *mongo.Collection, format string) ([]TimelineBucket, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"createdat": bson.M{"$type": "date"}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"$dateToString": bson.M{"format": format, "date": "$createdat"}},
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	}
+	defer timeQuery(coll, "aggregate", pipeline)()
+	cursor, err := coll.Aggregate(context.TODO(), pipeline)
+	if err != nil {
+		return nil, err
+	}
+	buckets := make([]TimelineBucket, 0)
+	if err = cursor.All(context.TODO(), &buckets); err != nil {
+		return nil, err
+	}
+	return buckets, nil
+}
+
+type YearCount struct {
+	Year  int `json:"year" bson:"_id"`
+	Count int `json:"count" bson:"count"`
+}
+
+// Counts the books published in each year, oldest first. Only years with
+// books show up, and books without a year (stored as 0) are left out.
+func aggregateYearCounts(coll *mongo.Collection) ([]YearCount, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"bookyear": bson.M{"$gt": 0}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$bookyear", "count": bson.M{"$sum": 1}}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	}
+	defer timeQuery(coll, "aggregate", pipeline)()
+	cursor, err := coll.Aggregate(context.TODO(), pipeline)
+	if err != nil {
+		return nil, err
+	}
+	counts := make([]YearCount, 0)
+	if err = cursor.All(context.TODO(), &counts); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// Returns every author once, sorted alphabetically by the given collation.
+// This used to be coll.Distinct, but Distinct cannot take a collation for
+// the sort: it hands the values back in no particular order, which we would
+// have to sort byte by byte ourselves. Grouping still lets the database
+// collect the authors instead of loading every book into memory.
+// Names differing only in case, like "Mary Shelley" and "mary shelley", are
+// one author: they are grouped by bookauthor_lower and shown as spelled by
+// the oldest of their books.
+func distinctAuthors(coll *mongo.Collection, collation *options.Collation) ([]string, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"bookauthor": bson.M{"$type": "string", "$ne": ""}}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+		{{Key: "$group", Value: bson.M{"_id": "$bookauthor_lower", "author": bson.M{"$first": "$bookauthor"}}}},
+		{{Key: "$sort", Value: bson.M{"author": 1}}},
+	}
+	defer timeQuery(coll, "aggregate", pipeline)()
+	cursor, err := coll.Aggregate(context.TODO(), pipeline, options.Aggregate().SetCollation(collation))
+	if err != nil {
+		return nil, err
+	}
+	var results []struct {
+		Author string `bson:"author"`
+	}
+	if err = cursor.All(context.TODO(), &results); err != nil {
+		return nil, err
+	}
+	authors := make([]string, 0, len(results))
+	for _, result := range results {
+		authors = append(authors, result.Author)
+	}
+	return authors, nil
+}
+
+// Groups the authors by the first letter of their surname, taken to be the
+// last word of the name ("Mary Shelley" is found under S). Names starting
+// with anything but a letter from A to Z, including accented letters, are
+// listed under "#". Within a letter the authors are sorted by the collation.
+func aggregateAuthorIndex(coll *mongo.Collection, collation *options.Collation) (map[string][]string, error) {
+	surname := bson.M{"$arrayElemAt": bson.A{bson.M{"$split": bson.A{bson.M{"$ifNull": bson.A{"$key", ""}}, " "}}, -1}}
+	initial := bson.M{"$toUpper": bson.M{"$substrCP": bson.A{surname, 0, 1}}}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"bookauthor": bson.M{"$type": "string", "$ne": ""}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$bookauthor", "key": bson.M{"$first": "$bookauthor_lower"}}}},
+		{{Key: "$set", Value: bson.M{"letter": initial}}},
+		{{Key: "$set", Value: bson.M{"letter": bson.M{"$cond": bson.A{
+			bson.M{"$regexMatch": bson.M{"input": "$letter", "regex": "^[A-Z]$"}}, "$letter", "#",
+		}}}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+		{{Key: "$group", Value: bson.M{"_id": "$letter", "authors": bson.M{"$push": "$_id"}}}},
+	}
+	defer timeQuery(coll, "aggregate", pipeline)()
+	cursor, err := coll.Aggregate(context.TODO(), pipeline, options.Aggregate().SetCollation(collation))
+	if err != nil {
+		return nil, err
+	}
+	var results []struct {
+		Letter  string   `bson:"_id"`
+		Authors []string `bson:"authors"`
+	}
+	if err = cursor.All(context.TODO(), &results); err != nil {
+		return nil, err
+	}
+	index := make(map[string][]string, len(results))
+	for _, result := range results {
+		index[result.Letter] = result.Authors
+	}
+	return index, nil
+}
+
+// Returns every publication year once, in ascending order. Books without a
+// year (stored as 0) are left out.
+func distinctYears(coll *mongo.Collection) ([]int, error) {
+	filter := bson.M{"bookyear": bson.M{"$ne": 0}}
+	defer timeQuery(coll, "distinct", filter)()
+	values, err := coll.Distinct(context.TODO(), "bookyear", filter)
+	if err != nil {
+		return nil, err
+	}
+	years := make([]int, 0, len(values))
+	for _, value := range values {
+		// The driver stores Go ints as int32 when they fit, so accept both
+		switch year := value.(type) {
+		case int32:
+			years = append(years, int(year))
+		case int64:
+			years = append(years, int(year))
+		}
+	}
+	sort.Ints(years)
+	return years, nil
+}
+
+// Keeps the latest author statistics in memory, so /api/stats/authors does
+// not have to aggregate the whole collection on every request. The cache is
+// refreshed by a background worker (see Run).
+type AuthorStatsCache struct {
+	mu    sync.RWMutex
+	coll  *mongo.Collection
+	stats []AuthorCount
+}
+
+func newAuthorStatsCache(coll *mongo.Collection) *AuthorStatsCache {
+	return &AuthorStatsCache{coll: coll}
+}
+
+// Recomputes the statistics and swaps them into the cache
+func (a *AuthorStatsCache) Refresh() error {
+	stats, err := aggregateAuthorCounts(a.coll)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	a.stats = stats
+	a.mu.Unlock()
+	return nil
+}
+
+// Returns the cached statistics, falling back to a live aggregation while
+// the cache is still cold (or the worker is disabled).
+func (a *AuthorStatsCache) Get() ([]AuthorCount, error) {
+	a.mu.RLock()
+	stats := a.stats
+	a.mu.RUnlock()
+	if stats != nil {
+		return stats, nil
+	}
+	return aggregateAuthorCounts(a.coll)
+}
+
+// Refreshes the cache every interval until the context is cancelled
+func (a *AuthorStatsCache) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	if err := a.Refresh(); err != nil {
+		log.Printf("warning: failed to refresh author statistics: %v\n", err)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := a.Refresh(); err != nil {
+				log.Printf("warning: failed to refresh author statistics: %v\n", err)
+			}
+		}
+	}
+}
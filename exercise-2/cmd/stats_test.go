@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestAuthorStatsCacheRefresh(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		cache := newAuthorStatsCache(mt.Coll)
+
+		mt.AddMockResponses(cursorReply(t, bson.M{"_id": "Mary Shelley", "count": 1}))
+		if err := cache.Refresh(); err != nil {
+			t.Fatal(err)
+		}
+		stats, err := cache.Get()
+		if err != nil || len(stats) != 1 || stats[0].Count != 1 {
+			t.Fatalf("got %+v, %v, want one book of Mary Shelley", stats, err)
+		}
+
+		// Served from memory until the next refresh
+		aggregates := len(sentCommands(mt, "aggregate"))
+		if _, err = cache.Get(); err != nil {
+			t.Fatal(err)
+		}
+		if n := len(sentCommands(mt, "aggregate")); n != aggregates {
+			t.Errorf("a warm cache sent %d aggregations", n-aggregates)
+		}
+
+		mt.AddMockResponses(cursorReply(t,
+			bson.M{"_id": "Mary Shelley", "count": 2},
+			bson.M{"_id": "Edgar Allan Poe", "count": 1},
+		))
+		if err = cache.Refresh(); err != nil {
+			t.Fatal(err)
+		}
+		stats, err = cache.Get()
+		if err != nil || len(stats) != 2 || stats[0].Count != 2 {
+			t.Errorf("got %+v, %v, want the refreshed counts", stats, err)
+		}
+	})
+}
+
+func TestDistinctAuthors(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+
+		// What the database answers for the seed data plus a "mary shelley"
+		// typed in lowercase: one group per lowercase name
+		mt.AddMockResponses(cursorReply(t,
+			bson.M{"_id": "edgar allan poe", "author": "Edgar Allan Poe"},
+			bson.M{"_id": "josé eustasio rivera", "author": "José Eustasio Rivera"},
+			bson.M{"_id": "mary shelley", "author": "Mary Shelley"},
+		))
+		rec := serve(e, http.MethodGet, "/api/authors", "")
+		var authors []string
+		if err := json.Unmarshal(rec.Body.Bytes(), &authors); err != nil {
+			t.Fatalf("body %s: %v", rec.Body.String(), err)
+		}
+		want := []string{"Edgar Allan Poe", "José Eustasio Rivera", "Mary Shelley"}
+		if !slices.Equal(authors, want) {
+			t.Errorf("authors = %q, want %q", authors, want)
+		}
+
+		command := sentCommands(mt, "aggregate")[0].Command
+		group := command.Lookup("pipeline").Array().Index(2).Value().Document().Lookup("$group").Document()
+		if key := group.Lookup("_id").StringValue(); key != "$bookauthor_lower" {
+			t.Errorf("authors grouped by %q, want the lowercase mirror", key)
+		}
+		if locale := command.Lookup("collation", "locale").StringValue(); locale != "en" {
+			t.Errorf("sorted with locale %q, want en", locale)
+		}
+	})
+}
+
+func TestDistinctYears(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+
+		// The years of the seed books, one of them stored as a 64-bit number
+		mt.AddMockResponses(mtest.CreateSuccessResponse(
+			bson.E{Key: "values", Value: bson.A{int32(1924), int64(1818), int32(1843)}},
+		))
+		rec := serve(e, http.MethodGet, "/api/years", "")
+		var years []int
+		if err := json.Unmarshal(rec.Body.Bytes(), &years); err != nil {
+			t.Fatalf("body %s: %v", rec.Body.String(), err)
+		}
+		if want := []int{1818, 1843, 1924}; !slices.Equal(years, want) {
+			t.Errorf("years = %v, want %v", years, want)
+		}
+
+		distinct := sentCommands(mt, "distinct")[0].Command
+		if key := distinct.Lookup("key").StringValue(); key != "bookyear" {
+			t.Errorf("distinct on %q, want bookyear", key)
+		}
+	})
+}
+
+func TestPageStats(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+
+		// What the database computes over the seed books (292, 280, 280)
+		mt.AddMockResponses(cursorReply(t, bson.M{"_id": nil, "total": 852, "average": 284.0, "min": 280, "max": 292}))
+		rec := serve(e, http.MethodGet, "/api/stats/pages", "")
+		var stats PageStats
+		if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+			t.Fatalf("body %s: %v", rec.Body.String(), err)
+		}
+		if want := (PageStats{Total: 852, Average: 284, Min: 280, Max: 292}); stats != want {
+			t.Errorf("stats = %+v, want %+v", stats, want)
+		}
+
+		// Books without a page count are left out of the average
+		group := sentCommands(mt, "aggregate")[0].Command.Lookup("pipeline").Array().Index(0).Value().Document()
+		if _, err := group.LookupErr("$group", "average", "$avg", "$cond"); err != nil {
+			t.Errorf("the average does not skip books without pages: %s", group)
+		}
+	})
+}
+
+func TestPageStatsEmpty(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		mt.AddMockResponses(cursorReply(t))
+		stats, err := aggregatePageStats(mt.Coll)
+		if err != nil || stats != (PageStats{}) {
+			t.Errorf("got %+v, %v, want zeros", stats, err)
+		}
+	})
+}
+
+func TestTimelineOfTwoDays(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+
+		// What the database computes over two books added one day and a
+		// third added the next
+		mt.AddMockResponses(cursorReply(t,
+			bson.M{"_id": "2024-05-01", "count": 2},
+			bson.M{"_id": "2024-05-02", "count": 1},
+		))
+		rec := serve(e, http.MethodGet, "/api/stats/timeline", "")
+		var buckets []TimelineBucket
+		if err := json.Unmarshal(rec.Body.Bytes(), &buckets); err != nil {
+			t.Fatalf("body %s: %v", rec.Body.String(), err)
+		}
+		want := []TimelineBucket{{Period: "2024-05-01", Count: 2}, {Period: "2024-05-02", Count: 1}}
+		if !slices.Equal(buckets, want) {
+			t.Errorf("buckets = %+v, want %+v", buckets, want)
+		}
+
+		group := sentCommands(mt, "aggregate")[0].Command.Lookup("pipeline").Array().Index(1).Value().Document()
+		format, err := group.LookupErr("$group", "_id", "$dateToString", "format")
+		if err != nil || format.StringValue() != "%Y-%m-%d" {
+			t.Errorf("the books are not grouped per day: %s", group)
+		}
+	})
+}
+
+func TestPageBucketsOfSeedBooks(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+
+		// What $bucket computes over the seed books (292, 280, 280): all of
+		// them in the bucket starting at 201
+		mt.AddMockResponses(cursorReply(t, bson.M{"_id": 201, "count": 3}))
+		rec := serve(e, http.MethodGet, "/api/stats/page-buckets", "")
+		var buckets []PageBucket
+		if err := json.Unmarshal(rec.Body.Bytes(), &buckets); err != nil {
+			t.Fatalf("body %s: %v", rec.Body.String(), err)
+		}
+		want := []PageBucket{{"0-100", 0}, {"101-200", 0}, {"201-300", 3}, {"300+", 0}}
+		if !slices.Equal(buckets, want) {
+			t.Errorf("buckets = %+v, want %+v", buckets, want)
+		}
+
+		// The seed books fall between the boundaries 201 and 301 sent along
+		bucket := sentCommands(mt, "aggregate")[0].Command.Lookup("pipeline").Array().Index(0).Value().Document()
+		values, err := bucket.Lookup("$bucket", "boundaries").Array().Values()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var boundaries []int64
+		for _, value := range values {
+			boundaries = append(boundaries, value.AsInt64())
+		}
+		if !slices.Equal(boundaries, []int64{0, 101, 201, 301}) {
+			t.Fatalf("boundaries %v, want [0 101 201 301]", boundaries)
+		}
+		for _, book := range seedBooks() {
+			if pages := int64(book.BookPages); pages < boundaries[2] || pages >= boundaries[3] {
+				t.Errorf("%s (%d pages) is not in the bucket starting at 201", book.BookName, pages)
+			}
+		}
+	})
+}
+
+// Books without pages are counted in the first range, and bucket ids come
+// back as doubles when the page counts are stored as such
+func TestPageBucketsZeroPagesAndDoubles(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+
+		mt.AddMockResponses(cursorReply(t, bson.M{"_id": 0.0, "count": 2}, bson.M{"_id": 201.0, "count": 3}))
+		rec := serve(e, http.MethodGet, "/api/stats/page-buckets", "")
+		var buckets []PageBucket
+		if err := json.Unmarshal(rec.Body.Bytes(), &buckets); err != nil {
+			t.Fatalf("body %s: %v", rec.Body.String(), err)
+		}
+		want := []PageBucket{{"0-100", 2}, {"101-200", 0}, {"201-300", 3}, {"300+", 0}}
+		if !slices.Equal(buckets, want) {
+			t.Errorf("buckets = %+v, want %+v", buckets, want)
+		}
+	})
+}
+
+func TestYearCountsOfSeedBooks(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+
+		// What the pipeline computes over the seed books, oldest first
+		mt.AddMockResponses(cursorReply(t,
+			bson.M{"_id": 1818, "count": 1}, bson.M{"_id": 1843, "count": 1}, bson.M{"_id": 1924, "count": 1}))
+		rec := serve(e, http.MethodGet, "/api/stats/by-year", "")
+		var counts []YearCount
+		if err := json.Unmarshal(rec.Body.Bytes(), &counts); err != nil {
+			t.Fatalf("body %s: %v", rec.Body.String(), err)
+		}
+		want := []YearCount{{1818, 1}, {1843, 1}, {1924, 1}}
+		if !slices.Equal(counts, want) {
+			t.Errorf("counts = %+v, want %+v", counts, want)
+		}
+
+		// The books are grouped by their year, after leaving out the ones
+		// without a year
+		pipeline := sentCommands(mt, "aggregate")[0].Command.Lookup("pipeline").Array()
+		if _, err := pipeline.Index(0).Value().Document().LookupErr("$match", "bookyear", "$gt"); err != nil {
+			t.Errorf("the books without a year are not left out: %s", pipeline)
+		}
+		group := pipeline.Index(1).Value().Document()
+		if id := group.Lookup("$group", "_id"); id.StringValue() != "$bookyear" {
+			t.Errorf("the books are grouped by %s, want $bookyear", id)
+		}
+		if sum := group.Lookup("$group", "count", "$sum"); sum.AsInt64() != 1 {
+			t.Errorf("the books are counted with $sum %s, want 1", sum)
+		}
+	})
+}
+
+// The index goes by surname, the last word of the name, so Mary Shelley is
+// found under S rather than M
+func TestAuthorIndexBySurname(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+
+		// What the database computes over the seed authors
+		mt.AddMockResponses(cursorReply(t,
+			bson.M{"_id": "P", "authors": bson.A{"Edgar Allan Poe"}},
+			bson.M{"_id": "R", "authors": bson.A{"José Eustasio Rivera"}},
+			bson.M{"_id": "S", "authors": bson.A{"Mary Shelley"}},
+		))
+		rec := serve(e, http.MethodGet, "/api/authors/index", "")
+		var index map[string][]string
+		if err := json.Unmarshal(rec.Body.Bytes(), &index); err != nil {
+			t.Fatalf("body %s: %v", rec.Body.String(), err)
+		}
+		if !slices.Equal(index["S"], []string{"Mary Shelley"}) || index["M"] != nil {
+			t.Errorf("index = %v, want Mary Shelley under S only", index)
+		}
+
+		// The letter is taken from the last word of the lowercase name
+		letter := sentCommands(mt, "aggregate")[0].Command.Lookup("pipeline").Array().Index(2).Value().Document()
+		surname, err := letter.LookupErr("$set", "letter", "$toUpper", "$substrCP")
+		if err != nil {
+			t.Fatalf("unexpected stage %s", letter)
+		}
+		word := surname.Array().Index(0).Value().Document().Lookup("$arrayElemAt").Array()
+		if position := word.Index(1).Value().AsInt64(); position != -1 {
+			t.Errorf("the letter comes from word %d of %s, want the last one", position, word)
+		}
+	})
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type TagDTO struct {
+	Tag string `json:"tag"`
+}
+
+// Applies a change to the tags of a book (e.g. $addToSet or $pull) and
+// returns its tags afterwards. Returns mongo.ErrNoDocuments when the book
+// does not exist.
+func updateTags(coll *mongo.Collection, id primitive.ObjectID, update bson.M) ([]string, error) {
+	update["$set"] = bson.M{"updatedat": time.Now().UTC()}
+
+	var book BookStore
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := coll.FindOneAndUpdate(context.TODO(), bson.M{"_id": id}, update, opts).Decode(&book)
+	if err != nil {
+		return nil, err
+	}
+	if book.Tags == nil {
+		return []string{}, nil
+	}
+	return book.Tags, nil
+}
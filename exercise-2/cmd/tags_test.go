@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestCreateWithTagsAndFilter(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+
+		mt.AddMockResponses(booksReply(t), writeReply(1))
+		rec := serve(e, http.MethodPost, "/api/books",
+			`{"name":"Frankenstein","author":"Mary Shelley","isbn":"978-3-649-64609-9","tags":["Gothic"," gothic ","Science Fiction"]}`)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("status = %d, want 201: %s", rec.Code, rec.Body.String())
+		}
+		var stored BookStore
+		if err := bson.Unmarshal(insertedDoc(t, mt, 0), &stored); err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"gothic", "science fiction"}; !slices.Equal(stored.Tags, want) {
+			t.Fatalf("stored tags %q, want %q", stored.Tags, want)
+		}
+
+		mt.AddMockResponses(booksReply(t, stored))
+		rec = serve(e, http.MethodGet, "/api/books?tag=Gothic", "")
+		var got []BookDTO
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil || len(got) != 1 {
+			t.Errorf("got %s, want Frankenstein", rec.Body.String())
+		}
+		finds := sentCommands(mt, "find")
+		if tag, _ := finds[len(finds)-1].Command.Lookup("filter", "tags").StringValueOK(); tag != "gothic" {
+			t.Errorf("filtered by tag %q, want gothic", tag)
+		}
+	})
+}
+
+func TestAddAndRemoveTag(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		book := seedBooks()[1]
+		book.Tags = []string{"gothic"}
+		path := "/api/books/" + book.ID.Hex() + "/tags"
+
+		// Adding a tag the book already has leaves its tags as they were
+		mt.AddMockResponses(findAndModifyReply(t, book))
+		rec := serve(e, http.MethodPost, path, `{"tag": " Gothic "}`)
+		if rec.Code != http.StatusOK || rec.Body.String() != `{"tags":["gothic"]}`+"\n" {
+			t.Errorf("add: got %d %s, want the tags unchanged", rec.Code, rec.Body.String())
+		}
+		update := sentCommands(mt, "findAndModify")[0].Command.Lookup("update").Document()
+		if tag, _ := update.Lookup("$addToSet", "tags").StringValueOK(); tag != "gothic" {
+			t.Errorf("update %s, want $addToSet of gothic", update)
+		}
+
+		book.Tags = nil
+		mt.AddMockResponses(findAndModifyReply(t, book))
+		rec = serve(e, http.MethodDelete, path+"/Gothic", "")
+		if rec.Code != http.StatusOK || rec.Body.String() != `{"tags":[]}`+"\n" {
+			t.Errorf("remove: got %d %s, want no tags left", rec.Code, rec.Body.String())
+		}
+		update = sentCommands(mt, "findAndModify")[1].Command.Lookup("update").Document()
+		if tag, _ := update.Lookup("$pull", "tags").StringValueOK(); tag != "gothic" {
+			t.Errorf("update %s, want $pull of gothic", update)
+		}
+	})
+}
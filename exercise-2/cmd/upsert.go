@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Answer of POST /api/books?upsert=true
+type UpsertResult struct {
+	Created bool    `json:"created"`
+	Book    BookDTO `json:"book"`
+}
+
+// Creates the book, or updates the one with the same ISBN, however it is
+// hyphenated. The second value is the book as it was before the update, nil
+// when it was created. Books synced from another catalog keep their id and
+// createdat this way.
+// It takes a single atomic round trip: the previous version comes back from
+// the update itself, and the id of a new book is picked here, so the stored
+// book is known without reading it again.
+func upsertBookByISBN(coll *mongo.Collection, book BookStore) (BookStore, *BookStore, error) {
+	now := time.Now().UTC()
+	newID := primitive.NewObjectID()
+	update := bson.M{
+		"$set": bson.M{
+			"bookname":         book.BookName,
+			"bookauthor":       book.BookAuthor,
+			"bookauthor_lower": book.BookAuthorLower,
+			"bookisbn":         book.BookISBN,
+			"bookisbn_digits":  book.BookISBNDigits,
+			"bookpages":        book.BookPages,
+			"bookyear":         book.BookYear,
+			"tags":             book.Tags,
+			"coverurl":         book.CoverURL,
+			"updatedat":        now,
+		},
+		"$setOnInsert": bson.M{"_id": newID, "createdat": now},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before)
+
+	var previous BookStore
+	err := coll.FindOneAndUpdate(context.TODO(), isbnFilter(book.BookISBN), update, opts).Decode(&previous)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return BookStore{}, nil, err
+	}
+
+	// $set replaced every other field, so only the id and createdat differ
+	// between a new and an updated book
+	stored := book
+	stored.UpdatedAt = now
+	if err == mongo.ErrNoDocuments {
+		stored.ID = newID
+		stored.CreatedAt = now
+		return stored, nil, nil
+	}
+	stored.ID = previous.ID
+	stored.CreatedAt = previous.CreatedAt
+	return stored, &previous, nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestUpsertSameISBNTwice(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+		upsert := func(body string) (int, UpsertResult) {
+			rec := serve(e, http.MethodPost, "/api/books?upsert=true", body)
+			var result UpsertResult
+			if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+				t.Fatalf("body %s: %v", rec.Body.String(), err)
+			}
+			return rec.Code, result
+		}
+
+		// Nothing with this ISBN yet
+		mt.AddMockResponses(findAndModifyReply(t, nil))
+		code, first := upsert(`{"name":"Frankenstein","author":"Mary Shelley","isbn":"978-3-649-64609-9","pages":280,"year":1818}`)
+		if code != http.StatusCreated || !first.Created {
+			t.Fatalf("got %d %+v, want the book created", code, first)
+		}
+
+		// The same ISBN without hyphens updates the book stored above
+		id, err := primitive.ObjectIDFromHex(first.Book.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored := BookStore{ID: id, BookName: "Frankenstein", BookAuthor: "Mary Shelley", BookISBN: "978-3-649-64609-9", BookPages: 280, BookYear: 1818}
+		mt.AddMockResponses(findAndModifyReply(t, stored), writeReply(1))
+		code, second := upsert(`{"name":"Frankenstein","author":"Mary Shelley","isbn":"9783649646099","pages":300,"year":1831}`)
+		if code != http.StatusOK || second.Created {
+			t.Fatalf("got %d %+v, want the book updated", code, second)
+		}
+		if second.Book.Id != first.Book.Id || second.Book.Pages != 300 || second.Book.Year != 1831 {
+			t.Errorf("got %+v, want book %s with the new pages and year", second.Book, first.Book.Id)
+		}
+
+		commands := sentCommands(mt, "findAndModify")
+		for _, command := range commands {
+			if !command.Command.Lookup("upsert").Boolean() {
+				t.Errorf("%s is not an upsert", command.Command)
+			}
+		}
+		firstQuery := commands[0].Command.Lookup("query").String()
+		if secondQuery := commands[1].Command.Lookup("query").String(); firstQuery != secondQuery {
+			t.Errorf("the upserts look up %s and %s, want the same book", firstQuery, secondQuery)
+		}
+	})
+}
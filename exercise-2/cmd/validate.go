@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Upper bounds meant to catch typos like a few zeros too many. They can be
+// raised through MAX_PAGES and MAX_YEAR, e.g. for multi-volume works.
+type BookLimits struct {
+	MaxPages int
+	// 0 stands for the current year, which moves on with the calendar
+	MaxYear int
+}
+
+func bookLimitsFromEnv() (BookLimits, error) {
+	maxPages, err := intFromEnv("MAX_PAGES", 10000)
+	if err != nil {
+		return BookLimits{}, err
+	}
+	maxYear, err := intFromEnv("MAX_YEAR", 0)
+	if err != nil {
+		return BookLimits{}, err
+	}
+	if maxPages < 1 || maxYear < 0 {
+		return BookLimits{}, fmt.Errorf("MAX_PAGES must be positive and MAX_YEAR must not be negative")
+	}
+	return BookLimits{MaxPages: maxPages, MaxYear: maxYear}, nil
+}
+
+// Checks the fields every stored book needs and returns one entry per
+// problem found, or nil when the book is fine.
+func validateBook(b BookStore, limits BookLimits) []FieldError {
+	var errs []FieldError
+	if b.BookName == "" {
+		errs = append(errs, FieldError{Field: "name", Message: "is required"})
+	}
+	if b.BookAuthor == "" {
+		errs = append(errs, FieldError{Field: "author", Message: "is required"})
+	}
+	if b.BookPages < 0 {
+		errs = append(errs, FieldError{Field: "pages", Message: "must not be negative"})
+	} else if b.BookPages > limits.MaxPages {
+		errs = append(errs, FieldError{Field: "pages", Message: fmt.Sprintf("must not be more than %d", limits.MaxPages)})
+	}
+	if b.BookYear < 0 {
+		errs = append(errs, FieldError{Field: "year", Message: "must not be negative"})
+	} else if limits.MaxYear == 0 && b.BookYear > time.Now().Year() {
+		errs = append(errs, FieldError{Field: "year", Message: "must not be in the future"})
+	} else if limits.MaxYear > 0 && b.BookYear > limits.MaxYear {
+		errs = append(errs, FieldError{Field: "year", Message: fmt.Sprintf("must not be after %d", limits.MaxYear)})
+	}
+	if b.BookISBN != "" && !isValidISBN(b.BookISBN) {
+		errs = append(errs, FieldError{Field: "isbn", Message: "is not a valid ISBN-10 or ISBN-13"})
+	}
+	if b.CoverURL != "" && !isWebURL(b.CoverURL) {
+		errs = append(errs, FieldError{Field: "coverUrl", Message: "must be an http or https URL"})
+	}
+	return errs
+}
+
+// Strips hyphens and spaces, so different spellings of an ISBN compare equal
+func isbnDigits(isbn string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(isbn)
+}
+
+// The value stored in bookisbn_digits: the ISBN without hyphens and spaces
+// and with an uppercase X, so every spelling of an ISBN has the same key.
+// bookisbn keeps the ISBN as it was entered, for display.
+func isbnKey(isbn string) string {
+	return strings.ToUpper(isbnDigits(isbn))
+}
+
+// Matches the book with the given ISBN however it was hyphenated
+func isbnFilter(isbn string) bson.M {
+	return bson.M{"bookisbn_digits": isbnKey(isbn)}
+}
+
+// Finds ISBNs used by more than one book of a batch. Inserting such a batch
+// would store one of them and reject the other, depending on the order.
+func duplicateISBNs(books []PostBookDTO) []FieldError {
+	positions := map[string][]int{}
+	var order []string
+	for i, book := range books {
+		digits := isbnKey(normalizeText(book.Isbn))
+		if digits == "" {
+			continue
+		}
+		if _, ok := positions[digits]; !ok {
+			order = append(order, digits)
+		}
+		positions[digits] = append(positions[digits], i)
+	}
+
+	var errs []FieldError
+	for _, digits := range order {
+		if indexes := positions[digits]; len(indexes) > 1 {
+			errs = append(errs, FieldError{
+				Field:   "isbn",
+				Message: fmt.Sprintf("%s is used by more than one book (positions %v)", books[indexes[0]].Isbn, indexes),
+			})
+		}
+	}
+	return errs
+}
+
+// Only absolute http(s) URLs are accepted, so a cover cannot smuggle in
+// something like javascript: into the pages showing it
+func isWebURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// Checks the check digit of an ISBN-10 or ISBN-13. Hyphens and spaces are
+// ignored, so "978-3-649-64609-9" is fine.
+// More on the check digits: https://en.wikipedia.org/wiki/ISBN#Check_digits
+func isValidISBN(isbn string) bool {
+	digits := isbnDigits(isbn)
+	switch len(digits) {
+	case 10:
+		// Weights 10 down to 1, the last digit may be X (10)
+		sum := 0
+		for i, r := range digits {
+			var d int
+			switch {
+			case r >= '0' && r <= '9':
+				d = int(r - '0')
+			case (r == 'X' || r == 'x') && i == 9:
+				d = 10
+			default:
+				return false
+			}
+			sum += (10 - i) * d
+		}
+		return sum%11 == 0
+	case 13:
+		// Alternating weights 1 and 3
+		sum := 0
+		for i, r := range digits {
+			if r < '0' || r > '9' {
+				return false
+			}
+			d := int(r - '0')
+			if i%2 == 1 {
+				d *= 3
+			}
+			sum += d
+		}
+		return sum%10 == 0
+	}
+	return false
+}
+
+// Answer of POST /api/books/validate
+type ValidationResult struct {
+	Valid  bool         `json:"valid"`
+	Errors []FieldError `json:"errors,omitempty"`
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// The fields a validation result complains about, in order
+func invalidFields(t *testing.T, body []byte) []string {
+	t.Helper()
+	var result ValidationResult
+	if err := json.Unmarshal(body, &result); err != nil {
+		t.Fatalf("body %s: %v", body, err)
+	}
+	fields := make([]string, 0, len(result.Errors))
+	for _, fieldErr := range result.Errors {
+		fields = append(fields, fieldErr.Field)
+	}
+	return fields
+}
+
+func TestValidateEndpoint(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+
+		rec := serve(e, http.MethodPost, "/api/books/validate",
+			`{"name":"Frankenstein","author":"Mary Shelley","pages":20000,"year":3000,"isbn":"123"}`)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want 400", rec.Code)
+		}
+		if fields, want := invalidFields(t, rec.Body.Bytes()), []string{"pages", "year", "isbn"}; !slices.Equal(fields, want) {
+			t.Errorf("invalid fields %q, want %q", fields, want)
+		}
+
+		rec = serve(e, http.MethodPost, "/api/books/validate", `{"name":"Frankenstein","author":"Mary Shelley"}`)
+		if rec.Code != http.StatusOK || rec.Body.String() != `{"valid":true}`+"\n" {
+			t.Errorf("got %d %s, want a valid book", rec.Code, rec.Body.String())
+		}
+		if started := mt.GetAllStartedEvents(); len(started) != 0 {
+			t.Errorf("validating sent %d commands", len(started))
+		}
+	})
+}
+
+func TestMaxPages(t *testing.T) {
+	book := BookStore{BookName: "In Search of Lost Time", BookAuthor: "Marcel Proust", BookPages: 50000}
+
+	t.Setenv("MAX_PAGES", "")
+	limits, err := bookLimitsFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs := validateBook(book, limits); len(errs) != 1 || errs[0].Field != "pages" {
+		t.Errorf("default limits: got %+v, want the pages rejected", errs)
+	}
+
+	t.Setenv("MAX_PAGES", "50000")
+	if limits, err = bookLimitsFromEnv(); err != nil {
+		t.Fatal(err)
+	}
+	if errs := validateBook(book, limits); errs != nil {
+		t.Errorf("MAX_PAGES=50000: got %+v, want the book accepted", errs)
+	}
+}
+
+func TestMaxPagesInvalid(t *testing.T) {
+	t.Setenv("MAX_PAGES", "0")
+	if _, err := bookLimitsFromEnv(); err == nil {
+		t.Error("MAX_PAGES=0 was accepted")
+	}
+}
+
+func TestBulkInsertDuplicateISBN(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+
+		// The same ISBN, once with hyphens and once without
+		rec := serve(e, http.MethodPost, "/api/books/bulk", `[
+			{"name":"Frankenstein","author":"Mary Shelley","isbn":"978-3-649-64609-9"},
+			{"name":"The Black Cat","author":"Edgar Allan Poe","isbn":"978-3-99168-238-7"},
+			{"name":"Frankenstein","author":"M. Shelley","isbn":"9783649646099"}
+		]`)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want 400", rec.Code)
+		}
+		var result ValidationResult
+		if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+			t.Fatalf("body %s: %v", rec.Body.String(), err)
+		}
+		if len(result.Errors) != 1 || result.Errors[0].Field != "isbn" || !strings.Contains(result.Errors[0].Message, "[0 2]") {
+			t.Errorf("errors = %+v, want one naming positions 0 and 2", result.Errors)
+		}
+		if inserts := sentCommands(mt, "insert"); len(inserts) != 0 {
+			t.Error("part of the batch was stored anyway")
+		}
+	})
+}
+
+func TestCreateWithoutAuthor(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+
+		rec := serve(e, http.MethodPost, "/api/books", `{"name":"Frankenstein","pages":280,"year":1818}`)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want 400", rec.Code)
+		}
+		if fields := invalidFields(t, rec.Body.Bytes()); !slices.Equal(fields, []string{"author"}) {
+			t.Errorf("invalid fields %q, want [author]", fields)
+		}
+		if started := mt.GetAllStartedEvents(); len(started) != 0 {
+			t.Errorf("sent %d commands for an invalid book", len(started))
+		}
+	})
+}
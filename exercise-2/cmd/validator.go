@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// Plugs go-playground/validator into echo, so handlers can check the
+// `validate:"..."` tags of a DTO with c.Validate right after c.Bind.
+// More on the tags: https://pkg.go.dev/github.com/go-playground/validator/v10
+type BookValidator struct {
+	validate *validator.Validate
+}
+
+func newBookValidator() *BookValidator {
+	v := validator.New(validator.WithRequiredStructEnabled())
+	// Report fields by their JSON name (e.g. "name" instead of "Name"),
+	// which is what the client sent
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	return &BookValidator{validate: v}
+}
+
+func (b *BookValidator) Validate(i interface{}) error {
+	return b.validate.Struct(i)
+}
+
+// Turns the error of c.Validate into our field errors. Returns nil for
+// errors that did not come from the validator.
+func fieldErrors(err error) []FieldError {
+	var validationErrs validator.ValidationErrors
+	if !errors.As(err, &validationErrs) {
+		return nil
+	}
+	errs := make([]FieldError, 0, len(validationErrs))
+	for _, fe := range validationErrs {
+		errs = append(errs, FieldError{Field: fe.Field(), Message: tagMessage(fe)})
+	}
+	return errs
+}
+
+func tagMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "gte":
+		if fe.Param() == "0" {
+			return "must not be negative"
+		}
+		return "must be at least " + fe.Param()
+	case "url", "http_url":
+		return "must be an http or https URL"
+	case "mongodb":
+		return "must be a book id"
+	}
+	return "is invalid"
+}
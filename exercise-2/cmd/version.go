@@ -0,0 +1,24 @@
+package main
+
+import "runtime"
+
+// Name of this service, also the default MONGO_APP_NAME
+const serviceName = "exercise-2"
+
+// Set at build time, e.g.
+//
+//	go build -ldflags "-X main.version=1.4.0" -o main ./cmd
+//
+// The Dockerfile passes its VERSION build argument in this way.
+var version = "dev"
+
+// Answer of GET /api/ping, to check which build is deployed
+type BuildInfo struct {
+	Service   string `json:"service"`
+	Version   string `json:"version"`
+	GoVersion string `json:"goVersion"`
+}
+
+func buildInfo() BuildInfo {
+	return BuildInfo{Service: serviceName, Version: version, GoVersion: runtime.Version()}
+}
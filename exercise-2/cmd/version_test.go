@@ -0,0 +1,27 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestPingNamesTheService(t *testing.T) {
+	withMockDB(t, func(t *testing.T, mt *mtest.T) {
+		e := newTestServer(testRepo(mt))
+
+		rec := serve(e, http.MethodGet, "/api/ping", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200", rec.Code)
+		}
+		var info BuildInfo
+		if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+			t.Fatalf("body %s: %v", rec.Body.String(), err)
+		}
+		if info.Service != "exercise-2" || info.Version == "" || info.GoVersion == "" {
+			t.Errorf("got %+v, want the service name, version and Go version", info)
+		}
+	})
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestMetricsAfterRequests(t *testing.T) {
+	mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock)).Run("mock", func(mt *mtest.T) {
+		e := echo.New()
+		metrics := newMetrics(mt.Coll)
+		e.Use(metrics.Middleware())
+		e.GET("/metrics", metrics.Handler)
+		e.DELETE("/api/books/:id", func(c echo.Context) error {
+			return c.NoContent(http.StatusOK)
+		})
+		e.GET("/broken", func(c echo.Context) error {
+			return echo.NewHTTPError(http.StatusInternalServerError, "broken")
+		})
+
+		e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/api/books/663a1f0c2b5e4a0001a1b2c3", nil))
+		e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/broken", nil))
+
+		// Reply to EstimatedDocumentCount
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 3}))
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		if rec.Code != http.StatusOK {
+			mt.Fatalf("status = %d, want 200", rec.Code)
+		}
+		body := rec.Body.String()
+		for _, want := range []string{
+			`http_requests_total{method="DELETE",route="/api/books/:id",status="200"} 1`,
+			`http_requests_total{method="GET",route="/broken",status="500"} 1`,
+			`http_request_errors_total{method="GET",route="/broken"} 1`,
+			`http_request_duration_seconds_count{method="DELETE",route="/api/books/:id"} 1`,
+			"books_total 3",
+		} {
+			if !strings.Contains(body, want) {
+				mt.Errorf("metrics lack %q:\n%s", want, body)
+			}
+		}
+	})
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// Stores a book and reads it back, checking the field names in between
+func TestBookStoreRoundTrip(t *testing.T) {
+	mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock)).Run("mock", func(mt *mtest.T) {
+		book := BookStore{BookName: "Frankenstein", BookAuthor: "Mary Shelley", BookISBN: "978-3-649-64609-9", BookPages: 280, BookYear: 1818}
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		if _, err := mt.Coll.InsertOne(context.TODO(), book); err != nil {
+			mt.Fatal(err)
+		}
+		stored := mt.GetStartedEvent().Command.Lookup("documents").Array().Index(0).Value().Document()
+		for _, field := range []string{"_id", "bookname", "bookauthor", "bookisbn", "bookpages", "bookyear"} {
+			if _, err := stored.LookupErr(field); err != nil {
+				mt.Errorf("the stored book %s lacks %s", stored, field)
+			}
+		}
+
+		var doc bson.D
+		if err := bson.Unmarshal(stored, &doc); err != nil {
+			mt.Fatal(err)
+		}
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "test.information", mtest.FirstBatch, doc))
+		var found BookStore
+		if err := mt.Coll.FindOne(context.TODO(), bson.M{"bookisbn": book.BookISBN}).Decode(&found); err != nil {
+			mt.Fatal(err)
+		}
+		found.ID = primitive.NilObjectID
+		if found != book {
+			mt.Errorf("read back %+v, want %+v", found, book)
+		}
+	})
+}
+
+func TestClientOptionsAppName(t *testing.T) {
+	t.Setenv("MONGO_APP_NAME", "catalog-get")
+	if appName := clientOptions("mongodb://mongo:27017").AppName; appName == nil || *appName != "catalog-get" {
+		t.Errorf("app name = %v, want catalog-get", appName)
+	}
+	t.Setenv("MONGO_APP_NAME", "")
+	if appName := clientOptions("mongodb://mongo:27017").AppName; appName == nil || *appName != "exercise-3-get" {
+		t.Errorf("app name = %v, want exercise-3-get", appName)
+	}
+}
+
+func TestWaitForDatabaseRetries(t *testing.T) {
+	calls := 0
+	ping := func(ctx context.Context) error {
+		calls++
+		if calls == 1 {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	if err := waitForDatabase(ping, 3, time.Millisecond); err != nil {
+		t.Fatalf("got %v, want the second ping to succeed", err)
+	}
+	if calls != 2 {
+		t.Errorf("pinged %d times, want 2", calls)
+	}
+}
+
+func TestWaitForDatabaseGivesUp(t *testing.T) {
+	calls := 0
+	down := errors.New("connection refused")
+	ping := func(ctx context.Context) error {
+		calls++
+		return down
+	}
+	if err := waitForDatabase(ping, 3, time.Millisecond); !errors.Is(err, down) {
+		t.Errorf("got %v, want the last ping error", err)
+	}
+	if calls != 3 {
+		t.Errorf("pinged %d times, want 3", calls)
+	}
+}
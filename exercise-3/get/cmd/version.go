@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Name of this service, also the default MONGO_APP_NAME
+const serviceName = "exercise-3-get"
+
+// Set at build time, e.g.
+//
+//	go build -ldflags "-X main.version=1.4.0" -o main ./cmd
+//
+// The Dockerfile passes its VERSION build argument in this way.
+var version = "dev"
+
+// Answer of GET /api/ping, to check which build is deployed
+type BuildInfo struct {
+	Service   string `json:"service"`
+	Version   string `json:"version"`
+	GoVersion string `json:"goVersion"`
+}
+
+func buildInfo() BuildInfo {
+	return BuildInfo{Service: serviceName, Version: version, GoVersion: runtime.Version()}
+}
+
+func pingHandler(c echo.Context) error {
+	return c.JSON(http.StatusOK, buildInfo())
+}
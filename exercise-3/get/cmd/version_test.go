@@ -0,0 +1,28 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestPingNamesTheService(t *testing.T) {
+	e := echo.New()
+	e.GET("/api/ping", pingHandler)
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/ping", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	var info BuildInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+		t.Fatalf("body %s: %v", rec.Body.String(), err)
+	}
+	if info.Service != "exercise-3-get" || info.Version == "" || info.GoVersion == "" {
+		t.Errorf("got %+v, want the service name, version and Go version", info)
+	}
+}
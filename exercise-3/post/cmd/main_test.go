@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// Reply to a find returning the given book
+func bookReply(t *testing.T, book BookStore) bson.D {
+	t.Helper()
+	data, err := bson.Marshal(book)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc bson.D
+	if err = bson.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	return mtest.CreateCursorResponse(0, "test.information", mtest.FirstBatch, doc)
+}
+
+// The sample books are found by their ISBN alone, so a second start does not
+// insert them again, even after one of them was edited
+func TestPrepareDataFindsStoredBooks(t *testing.T) {
+	mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock)).Run("mock", func(mt *mtest.T) {
+		stored := []BookStore{
+			{BookName: "The Vortex", BookAuthor: "José Eustasio Rivera", BookISBN: "958-30-0804-4", BookPages: 292, BookYear: 1924},
+			{BookName: "Frankenstein", BookAuthor: "Mary Shelley", BookISBN: "978-3-649-64609-9", BookPages: 280, BookYear: 1818},
+			{BookName: "The Black Cat", BookAuthor: "Edgar Allan Poe", BookISBN: "978-3-99168-238-7", BookPages: 280, BookYear: 1843},
+		}
+		for _, book := range stored {
+			mt.AddMockResponses(bookReply(mt.T, book))
+		}
+		prepareData(mt.Client, mt.Coll)
+
+		for _, started := range mt.GetAllStartedEvents() {
+			switch started.CommandName {
+			case "insert":
+				mt.Error("a sample book was inserted again")
+			case "find":
+				filter := started.Command.Lookup("filter").Document()
+				if elements, _ := filter.Elements(); len(elements) != 1 || elements[0].Key() != "bookisbn" {
+					mt.Errorf("the filter %s is not the ISBN alone", filter)
+				}
+			}
+		}
+	})
+}
+
+// Problems with single sample books are logged and skipped instead of
+// stopping the server: a book stored twice, a failed lookup and a rejected
+// insert
+func TestPrepareDataSkipsProblems(t *testing.T) {
+	mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock)).Run("mock", func(mt *mtest.T) {
+		vortex := bson.D{{Key: "bookisbn", Value: "958-30-0804-4"}}
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "test.information", mtest.FirstBatch, vortex, vortex),
+			mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 13, Message: "not authorized"}),
+			mtest.CreateCursorResponse(0, "test.information", mtest.FirstBatch),
+			mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 0, Code: 11000, Message: "E11000 duplicate key error"}),
+		)
+		prepareData(mt.Client, mt.Coll)
+
+		var finds, inserts int
+		for _, started := range mt.GetAllStartedEvents() {
+			switch started.CommandName {
+			case "find":
+				finds++
+			case "insert":
+				inserts++
+			}
+		}
+		if finds != 3 || inserts != 1 {
+			mt.Errorf("sent %d finds and %d inserts, want 3 and 1", finds, inserts)
+		}
+	})
+}
+
+// Stores a book and reads it back, checking the field names in between
+func TestBookStoreRoundTrip(t *testing.T) {
+	mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock)).Run("mock", func(mt *mtest.T) {
+		book := BookStore{BookName: "Frankenstein", BookAuthor: "Mary Shelley", BookISBN: "978-3-649-64609-9", BookPages: 280, BookYear: 1818}
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		if _, err := mt.Coll.InsertOne(context.TODO(), book); err != nil {
+			mt.Fatal(err)
+		}
+		stored := mt.GetStartedEvent().Command.Lookup("documents").Array().Index(0).Value().Document()
+		for _, field := range []string{"_id", "bookname", "bookauthor", "bookisbn", "bookpages", "bookyear"} {
+			if _, err := stored.LookupErr(field); err != nil {
+				mt.Errorf("the stored book %s lacks %s", stored, field)
+			}
+		}
+
+		var doc bson.D
+		if err := bson.Unmarshal(stored, &doc); err != nil {
+			mt.Fatal(err)
+		}
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "test.information", mtest.FirstBatch, doc))
+		var found BookStore
+		if err := mt.Coll.FindOne(context.TODO(), bson.M{"bookisbn": book.BookISBN}).Decode(&found); err != nil {
+			mt.Fatal(err)
+		}
+		found.ID = primitive.NilObjectID
+		if found != book {
+			mt.Errorf("read back %+v, want %+v", found, book)
+		}
+	})
+}
+
+func TestClientOptionsAppName(t *testing.T) {
+	t.Setenv("MONGO_APP_NAME", "catalog-post")
+	if appName := clientOptions("mongodb://mongo:27017").AppName; appName == nil || *appName != "catalog-post" {
+		t.Errorf("app name = %v, want catalog-post", appName)
+	}
+	t.Setenv("MONGO_APP_NAME", "")
+	if appName := clientOptions("mongodb://mongo:27017").AppName; appName == nil || *appName != "exercise-3-post" {
+		t.Errorf("app name = %v, want exercise-3-post", appName)
+	}
+}
+
+func TestWaitForDatabaseRetries(t *testing.T) {
+	calls := 0
+	ping := func(ctx context.Context) error {
+		calls++
+		if calls == 1 {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	if err := waitForDatabase(ping, 3, time.Millisecond); err != nil {
+		t.Fatalf("got %v, want the second ping to succeed", err)
+	}
+	if calls != 2 {
+		t.Errorf("pinged %d times, want 2", calls)
+	}
+}
+
+func TestWaitForDatabaseGivesUp(t *testing.T) {
+	calls := 0
+	down := errors.New("connection refused")
+	ping := func(ctx context.Context) error {
+		calls++
+		return down
+	}
+	if err := waitForDatabase(ping, 3, time.Millisecond); !errors.Is(err, down) {
+		t.Errorf("got %v, want the last ping error", err)
+	}
+	if calls != 3 {
+		t.Errorf("pinged %d times, want 3", calls)
+	}
+}
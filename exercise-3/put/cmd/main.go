@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os"
 	"slices"
+	"strconv"
 	"time"
 
 	"github.com/labstack/echo/v4"
@@ -20,11 +21,11 @@ import (
 
 type BookStore struct {
 	ID         primitive.ObjectID `bson:"_id,omitempty"`
-	BookName   string
-	BookAuthor string
-	BookISBN   string
-	BookPages  int
-	BookYear   int
+	BookName   string             `bson:"bookname"`
+	BookAuthor string             `bson:"bookauthor"`
+	BookISBN   string             `bson:"bookisbn"`
+	BookPages  int                `bson:"bookpages"`
+	BookYear   int                `bson:"bookyear"`
 }
 
 // Here we make sure the connection to the database is correct and initial
@@ -89,27 +90,31 @@ func prepareData(client *mongo.Client, coll *mongo.Collection) {
 	// return a tuple with (res, err), but this is not granted. Some functions
 	// might return a ret value that includes res and the err, others might have
 	// an out parameter.
+	// Seed books are matched by their ISBN only, so restarting the server (or
+	// a seed book being edited afterwards) never inserts them a second time.
 	for _, book := range startData {
-		cursor, err := coll.Find(context.TODO(), book)
+		cursor, err := coll.Find(context.TODO(), bson.M{"bookisbn": book.BookISBN})
+		if err != nil {
+			log.Printf("warning: failed to look up seed book %s: %v\n", book.BookISBN, err)
+			continue
+		}
 		var results []BookStore
 		if err = cursor.All(context.TODO(), &results); err != nil {
-			panic(err)
+			log.Printf("warning: failed to decode seed book %s: %v\n", book.BookISBN, err)
+			continue
 		}
 		if len(results) > 1 {
-			log.Fatal("more records were found")
+			log.Printf("warning: found %d records for seed book %s, skipping\n", len(results), book.BookISBN)
 		} else if len(results) == 0 {
 			result, err := coll.InsertOne(context.TODO(), book)
 			if err != nil {
-				panic(err)
+				log.Printf("warning: failed to insert seed book %s: %v\n", book.BookISBN, err)
 			} else {
 				fmt.Printf("%+v\n", result)
 			}
 
 		} else {
-			for _, res := range results {
-				cursor.Decode(&res)
-				fmt.Printf("%+v\n", res)
-			}
+			fmt.Printf("%+v\n", results[0])
 		}
 	}
 }
@@ -123,6 +128,58 @@ type BookDTO struct {
 	Isbn   string `json:"isbn,omitempty"`
 }
 
+// The app name tells the services apart in the MongoDB logs. It can be
+// changed with MONGO_APP_NAME.
+func clientOptions(uri string) *options.ClientOptions {
+	appName := os.Getenv("MONGO_APP_NAME")
+	if appName == "" {
+		appName = serviceName
+	}
+	return options.Client().ApplyURI(uri).SetAppName(appName)
+}
+
+// How often the startup ping is tried (MONGO_CONNECT_ATTEMPTS, default 5)
+// and how long to wait in between (MONGO_CONNECT_INTERVAL, default 2s)
+func connectRetriesFromEnv() (int, time.Duration, error) {
+	attempts, interval := 5, 2*time.Second
+	if value := os.Getenv("MONGO_CONNECT_ATTEMPTS"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return 0, 0, fmt.Errorf("MONGO_CONNECT_ATTEMPTS must be a positive number")
+		}
+		attempts = n
+	}
+	if value := os.Getenv("MONGO_CONNECT_INTERVAL"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return 0, 0, fmt.Errorf("MONGO_CONNECT_INTERVAL must be a duration like 2s")
+		}
+		interval = d
+	}
+	return attempts, interval, nil
+}
+
+// Pings the database until it answers, trying at most attempts times and
+// waiting interval between tries, since MongoDB often starts a few seconds
+// after this service in docker compose
+func waitForDatabase(ping func(ctx context.Context) error, attempts int, interval time.Duration) error {
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err = ping(ctx)
+		cancel()
+		if err == nil {
+			log.Printf("connected to MongoDB (attempt %d/%d)\n", attempt, attempts)
+			return nil
+		}
+		log.Printf("MongoDB is not reachable yet (attempt %d/%d): %v\n", attempt, attempts, err)
+		if attempt < attempts {
+			time.Sleep(interval)
+		}
+	}
+	return err
+}
+
 func main() {
 	// Connect to the database. Such defer keywords are used once the local
 	// context returns; for this case, the local context is the main function
@@ -137,12 +194,19 @@ func main() {
 		fmt.Printf("failure to load env variable\n")
 		os.Exit(1)
 	}
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	attempts, interval, err := connectRetriesFromEnv()
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+	client, err := mongo.Connect(ctx, clientOptions(uri))
 	if err != nil {
 		fmt.Printf("failed to create client for MongoDB\n")
 		os.Exit(1)
 	}
-	err = client.Ping(ctx, readpref.Primary())
+	err = waitForDatabase(func(ctx context.Context) error {
+		return client.Ping(ctx, readpref.Primary())
+	}, attempts, interval)
 	if err != nil {
 		fmt.Printf("failed to connect to MongoDB, please make sure the database is running\n")
 		os.Exit(1)
@@ -160,6 +224,14 @@ func main() {
 	e := echo.New()
 	e.Use(middleware.Logger())
 
+	// Request counts, latencies and the amount of books for Prometheus
+	metrics := newMetrics(coll)
+	e.Use(metrics.Middleware())
+	e.GET("/metrics", metrics.Handler)
+
+	// Which build of this service is deployed
+	e.GET("/api/ping", pingHandler)
+
 	e.PUT("/api/books", func(c echo.Context) error {
 		bookToUpdate := new(BookDTO)
 		if err := c.Bind(bookToUpdate); err != nil {
@@ -170,12 +242,15 @@ func main() {
 			context.TODO(),
 			bson.M{"_id": id},
 			bson.M{
+				// The driver stores the fields under their lowercased
+				// names (bookname, ...), so these keys have to match them.
+				// PascalCase keys would add new fields instead.
 				"$set": bson.M{
-					"BookName":   bookToUpdate.Name,
-					"BookAuthor": bookToUpdate.Author,
-					"BookPages":  bookToUpdate.Pages,
-					"BookYear":   bookToUpdate.Year,
-					"BookISBN":   bookToUpdate.Isbn,
+					"bookname":   bookToUpdate.Name,
+					"bookauthor": bookToUpdate.Author,
+					"bookpages":  bookToUpdate.Pages,
+					"bookyear":   bookToUpdate.Year,
+					"bookisbn":   bookToUpdate.Isbn,
 				},
 			})
 		fmt.Println(result)